@@ -8,10 +8,9 @@ import (
 	"os"
 	"strconv"
 
+	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/joho/godotenv"
 	"s3-wal-demo/s3_log"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 )
 
 func main() {
@@ -23,11 +22,17 @@ func main() {
 	awsRegion := flag.String("region", os.Getenv("AWS_REGION"), "AWS region")
 	bucket := flag.String("bucket", os.Getenv("AWS_BUCKET_NAME"), "S3 bucket name")
 	prefix := flag.String("prefix", os.Getenv("AWS_PREFIX"), "S3 prefix for WAL")
+	minRetained := flag.Int("min-retained", 0, "refuse to truncate below this many records")
+	force := flag.Bool("force", false, "truncate even below -min-retained")
+	root := flag.Bool("root", false, "allow an empty prefix and keep the WAL at the bucket root")
+	accelerate := flag.Bool("accelerate", false, "use the S3 Transfer Acceleration endpoint")
+	dualStack := flag.Bool("dualstack", false, "use the dual-stack (IPv4/IPv6) endpoint")
+	pathStyle := flag.Bool("path-style", false, "send path-style requests, for gateways without virtual-hosted buckets")
 	flag.Parse()
 
 	if len(flag.Args()) < 1 {
 		fmt.Println("Usage: s3wal <command> [args]")
-		fmt.Println("Commands: append <data>, read <offset>, last, truncate <offset>, recover")
+		fmt.Println("Commands: append <data>, read <offset>, last, truncate <offset>, recover, follow [from], import <file>, export <file>, check")
 		return
 	}
 
@@ -35,8 +40,23 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	client := s3.NewFromConfig(cfg)
-	wal := s3_log.NewS3WAL(client, *bucket, *prefix)
+	opts := []s3_log.Option{s3_log.WithMinRetainedRecords(*minRetained)}
+	if *root {
+		opts = append(opts, s3_log.WithRootPrefix())
+	}
+	if *accelerate {
+		opts = append(opts, s3_log.WithTransferAcceleration())
+	}
+	if *dualStack {
+		opts = append(opts, s3_log.WithDualStack())
+	}
+	if *pathStyle {
+		opts = append(opts, s3_log.WithForcePathStyle())
+	}
+	wal, err := s3_log.NewS3WALFromConfig(cfg, *bucket, *prefix, opts...)
+	if err != nil {
+		log.Fatal(err)
+	}
 	ctx := context.TODO()
 
 	cmd := flag.Arg(0)
@@ -81,7 +101,11 @@ func main() {
 		if err != nil {
 			log.Fatalf("Invalid offset: %v", err)
 		}
-		if err := wal.Truncate(ctx, offset); err != nil {
+		truncate := wal.Truncate
+		if *force {
+			truncate = wal.ForceTruncate
+		}
+		if err := truncate(ctx, offset); err != nil {
 			log.Fatalf("Truncate failed: %v", err)
 		}
 		fmt.Printf("Truncated WAL after offset %d\n", offset)
@@ -93,8 +117,67 @@ func main() {
 		}
 		fmt.Printf("Last offset: %d\n", lastOffset)
 
+	case "follow":
+		var from uint64
+		if len(flag.Args()) >= 2 {
+			from, err = strconv.ParseUint(flag.Arg(1), 10, 64)
+			if err != nil {
+				log.Fatalf("Invalid offset: %v", err)
+			}
+		}
+		records, errs := wal.Follow(ctx, from)
+		for rec := range records {
+			fmt.Printf("Offset: %d, Data: %s\n", rec.Offset, string(rec.Data))
+		}
+		if err := <-errs; err != nil {
+			log.Fatalf("Follow failed: %v", err)
+		}
+
+	case "check":
+		firstGap, ok, err := wal.CheckContiguous(ctx)
+		if err != nil {
+			log.Fatalf("Check failed: %v", err)
+		}
+		if !ok {
+			log.Fatalf("WAL is not contiguous, first missing offset: %d", firstGap)
+		}
+		fmt.Println("WAL is contiguous")
+
+	case "import":
+		if len(flag.Args()) < 2 {
+			log.Fatal("Usage: s3wal import <file>")
+		}
+		f, err := os.Open(flag.Arg(1))
+		if err != nil {
+			log.Fatalf("Open failed: %v", err)
+		}
+		defer f.Close()
+		if _, err := wal.Recover(ctx); err != nil {
+			log.Fatalf("Recover failed: %v", err)
+		}
+		lastOffset, err := wal.ImportFrom(ctx, f)
+		if err != nil {
+			log.Fatalf("Import failed at offset %d: %v", lastOffset, err)
+		}
+		fmt.Printf("Imported up to offset: %d\n", lastOffset)
+
+	case "export":
+		if len(flag.Args()) < 2 {
+			log.Fatal("Usage: s3wal export <file>")
+		}
+		f, err := os.Create(flag.Arg(1))
+		if err != nil {
+			log.Fatalf("Create failed: %v", err)
+		}
+		defer f.Close()
+		lastOffset, err := wal.ExportTo(ctx, f)
+		if err != nil {
+			log.Fatalf("Export failed: %v", err)
+		}
+		fmt.Printf("Exported up to offset: %d\n", lastOffset)
+
 	default:
 		fmt.Println("Unknown command:", cmd)
-		fmt.Println("Commands: append <data>, read <offset>, last, truncate <offset>, recover")
+		fmt.Println("Commands: append <data>, read <offset>, last, truncate <offset>, recover, follow [from], import <file>, export <file>, check")
 	}
 }
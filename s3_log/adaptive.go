@@ -0,0 +1,150 @@
+package s3_log
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// readLimiter bounds the downloads a concurrent read keeps in flight.
+type readLimiter interface {
+	// acquire waits for a slot; it fails only if ctx is done.
+	acquire(ctx context.Context) error
+	// release returns the slot of a download started at start that ended
+	// with err.
+	release(start time.Time, err error)
+}
+
+// readLimiter returns the limiter of one concurrent read: the shared
+// WithAdaptiveConcurrency one, or n fixed slots.
+func (w *S3WAL) readLimiter(n int) readLimiter {
+	if w.adaptive != nil {
+		return w.adaptive
+	}
+	return make(fixedLimiter, n)
+}
+
+// fixedLimiter is a semaphore of a fixed number of slots.
+type fixedLimiter chan struct{}
+
+func (l fixedLimiter) acquire(ctx context.Context) error {
+	select {
+	case l <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l fixedLimiter) release(time.Time, error) {
+	<-l
+}
+
+// Latency more than adaptiveSlowFactor times the average counts as
+// congestion, like a throttling error.
+const adaptiveSlowFactor = 2
+
+// adaptiveLimiter is the WithAdaptiveConcurrency limiter, shared by all
+// concurrent reads of an S3WAL. Its level grows by one per level downloads
+// that complete in time and halves on congestion, at most once per level
+// downloads so that one burst of slow responses counts once.
+type adaptiveLimiter struct {
+	min, max int
+
+	mu       sync.Mutex
+	limit    float64       // current level, min to max
+	inflight int           // slots taken
+	avg      time.Duration // moving average of download latency, 0 until the first
+	since    int           // downloads done since the last decrease
+	wake     chan struct{} // closed and replaced when a slot is released
+}
+
+func newAdaptiveLimiter(min, max int) *adaptiveLimiter {
+	return &adaptiveLimiter{
+		min:   min,
+		max:   max,
+		limit: float64(min),
+		wake:  make(chan struct{}),
+	}
+}
+
+func (l *adaptiveLimiter) acquire(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		if l.inflight < int(l.limit) {
+			l.inflight++
+			l.mu.Unlock()
+			return nil
+		}
+		wake := l.wake
+		l.mu.Unlock()
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (l *adaptiveLimiter) release(start time.Time, err error) {
+	latency := time.Since(start)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inflight--
+	l.since++
+
+	congested := isCongestion(err) || (err == nil && l.avg > 0 && latency > adaptiveSlowFactor*l.avg)
+	if err == nil {
+		if l.avg == 0 {
+			l.avg = latency
+		} else {
+			// a lasting change becomes the new normal
+			l.avg += (latency - l.avg) / 8
+		}
+	}
+	if congested {
+		if l.since >= int(l.limit) {
+			l.limit = max(float64(l.min), l.limit/2)
+			l.since = 0
+		}
+	} else if err == nil {
+		l.limit = min(float64(l.max), l.limit+1/l.limit)
+	}
+
+	close(l.wake)
+	l.wake = make(chan struct{})
+}
+
+// ReadConcurrency returns the number of downloads concurrent reads may
+// currently keep in flight under WithAdaptiveConcurrency, e.g. to export it as
+// a gauge, or 0 without it.
+func (w *S3WAL) ReadConcurrency() int {
+	if w.adaptive == nil {
+		return 0
+	}
+	w.adaptive.mu.Lock()
+	defer w.adaptive.mu.Unlock()
+	return int(w.adaptive.limit)
+}
+
+// isCongestion reports whether err is S3 throttling or failing, or the
+// request not getting through, rather than e.g. a missing key, a damaged
+// record or a cancelled read.
+func isCongestion(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) {
+		return respErr.HTTPStatusCode() == http.StatusTooManyRequests || respErr.HTTPStatusCode() >= 500
+	}
+	var sendErr *smithyhttp.RequestSendError
+	var netErr net.Error
+	return errors.As(err, &sendErr) || errors.As(err, &netErr)
+}
@@ -0,0 +1,31 @@
+package s3_log
+
+import (
+	"errors"
+	"fmt"
+)
+
+// OffsetAllocator hands out the offsets Append stores records at, see
+// WithOffsetAllocator. Next must never return an offset twice across all
+// writers sharing the WAL; an offset whose write then fails is not reused.
+// Implementations may, for instance, reserve blocks of offsets from a shared
+// counter object so that each writer coordinates once per block.
+type OffsetAllocator interface {
+	Next() (uint64, error)
+}
+
+// allocateOffset returns the offset of the next Append: the one after the
+// cached length, or the WithOffsetAllocator's choice. Callers must hold w.mu.
+func (w *S3WAL) allocateOffset() (uint64, error) {
+	if w.allocator == nil {
+		return w.length + 1, nil
+	}
+	offset, err := w.allocator.Next()
+	if err != nil {
+		return 0, fmt.Errorf("allocate offset: %w", err)
+	}
+	if offset == 0 {
+		return 0, errors.New("allocate offset: offset 0 is not a valid record offset")
+	}
+	return offset, nil
+}
@@ -0,0 +1,34 @@
+package s3_log
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// S3API is the subset of *s3.Client an S3WAL calls, with the SDK's method
+// signatures, so that tests can inject a hand-written implementation with
+// NewS3WALWithAPI. Listings go through s3.NewListObjectsV2Paginator on top of
+// ListObjectsV2, so a mock only has to honour ContinuationToken and
+// NextContinuationToken to page.
+type S3API interface {
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+	ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error)
+	DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
+	DeleteObjects(ctx context.Context, params *s3.DeleteObjectsInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error)
+	HeadBucket(ctx context.Context, params *s3.HeadBucketInput, optFns ...func(*s3.Options)) (*s3.HeadBucketOutput, error)
+}
+
+var _ S3API = (*s3.Client)(nil)
+
+// NewS3WALWithAPI is NewS3WAL on top of any S3API, e.g. a mock in tests.
+// Like NewS3WAL it panics on configuration errors; WithMaxConcurrentOps needs
+// an *s3.Client and is one otherwise.
+func NewS3WALWithAPI(api S3API, bucketName, prefix string, opts ...Option) *S3WAL {
+	w := newS3WAL(api, bucketName, prefix, opts)
+	w.mustValidate()
+	w.start()
+	return w
+}
@@ -0,0 +1,115 @@
+package s3_log
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// importBatchSize is the number of frames ImportFrom hands to BatchAppend at once.
+const importBatchSize = 100
+
+// maxFrameSize bounds the record data of one frame ImportFrom accepts, so
+// that a corrupt or foreign length is not allocated blindly.
+const maxFrameSize = 64 << 20
+
+// ImportFrom appends every length-prefixed frame read from r and returns the
+// offset of the last record in the WAL afterwards. A frame is a 4-byte
+// big-endian length followed by that many bytes of record data, the format
+// ExportTo writes. A frame longer than maxFrameSize fails the import with
+// ErrFrameTooLarge. Frames are appended in batches through BatchAppend; on
+// error the records appended so far are kept.
+func (w *S3WAL) ImportFrom(ctx context.Context, r io.Reader) (uint64, error) {
+	batch := make([][]byte, 0, importBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		_, err := w.BatchAppend(ctx, batch, FailFast)
+		batch = batch[:0]
+		return err
+	}
+
+	var header [4]byte
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return w.Length(), fmt.Errorf("read frame length: %w", err)
+		}
+		size := binary.BigEndian.Uint32(header[:])
+		if size > maxFrameSize {
+			return w.Length(), fmt.Errorf("%w: %d bytes, the maximum is %d", ErrFrameTooLarge, size, maxFrameSize)
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(r, data); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return w.Length(), fmt.Errorf("read frame data: %w", err)
+		}
+		batch = append(batch, data)
+		if len(batch) == importBatchSize {
+			if err := flush(); err != nil {
+				return w.Length(), err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return w.Length(), err
+	}
+	return w.Length(), nil
+}
+
+// ExportTo writes every stored record to out in offset order as a
+// length-prefixed frame (see ImportFrom) and returns the offset of the last
+// record written. In segment mode buffered records are flushed first.
+func (w *S3WAL) ExportTo(ctx context.Context, out io.Writer) (uint64, error) {
+	if err := w.Flush(ctx); err != nil {
+		return 0, fmt.Errorf("flush before export: %w", err)
+	}
+
+	var lastOffset uint64
+	var header [4]byte
+	err := w.walkObjects(ctx, func(obj types.Object, first, last uint64) error {
+		records, err := w.readObject(ctx, aws.ToString(obj.Key), first, last)
+		if err != nil {
+			return err
+		}
+		for _, rec := range records {
+			binary.BigEndian.PutUint32(header[:], uint32(len(rec.Data)))
+			if _, err := out.Write(header[:]); err != nil {
+				return fmt.Errorf("write frame (offset=%d): %w", rec.Offset, err)
+			}
+			if _, err := out.Write(rec.Data); err != nil {
+				return fmt.Errorf("write frame (offset=%d): %w", rec.Offset, err)
+			}
+			lastOffset = rec.Offset
+		}
+		return nil
+	})
+	return lastOffset, err
+}
+
+// readObject downloads a WAL object covering first..last and returns the
+// records it holds, one for a plain record object.
+func (w *S3WAL) readObject(ctx context.Context, key string, first, last uint64) ([]Record, error) {
+	data, err := w.getBody(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if isSegment(data) {
+		return w.decodeSegment(key, data, first, last)
+	}
+	rec, err := w.decodeRecord(key, first, data)
+	if err != nil {
+		return nil, err
+	}
+	return []Record{rec}, nil
+}
@@ -0,0 +1,53 @@
+package s3_log
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// ExportTo and ImportFrom round-trip records.
+func TestExportImport(t *testing.T) {
+	_, client := newFake(t)
+	src := NewS3WAL(client, "bucket", "src")
+	defer src.Close()
+	for _, data := range []string{"a", "", "ccc"} {
+		if _, err := src.Append(bg, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var buf bytes.Buffer
+	if last, err := src.ExportTo(bg, &buf); err != nil || last != 3 {
+		t.Fatalf("ExportTo = %d, %v, want 3", last, err)
+	}
+
+	dst := NewS3WAL(client, "bucket", "dst")
+	defer dst.Close()
+	if last, err := dst.ImportFrom(bg, &buf); err != nil || last != 3 {
+		t.Fatalf("ImportFrom = %d, %v, want 3", last, err)
+	}
+	rec, err := dst.Read(bg, 3)
+	if err != nil || string(rec.Data) != "ccc" {
+		t.Fatalf("Read(3) = %q, %v, want %q", rec.Data, err, "ccc")
+	}
+}
+
+// ImportFrom rejects a frame length above maxFrameSize instead of allocating
+// it.
+func TestImportFromRejectsHugeFrame(t *testing.T) {
+	_, client := newFake(t)
+	w := NewS3WAL(client, "bucket", "wal")
+	defer w.Close()
+
+	var buf bytes.Buffer
+	buf.Write([]byte{0, 0, 0, 1, 'a'})
+	binary.Write(&buf, binary.BigEndian, uint32(maxFrameSize+1))
+	last, err := w.ImportFrom(bg, &buf)
+	if !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("ImportFrom error = %v, want ErrFrameTooLarge", err)
+	}
+	if last != 0 {
+		t.Fatalf("ImportFrom = %d, want 0 as its batch was not appended yet", last)
+	}
+}
@@ -0,0 +1,210 @@
+package s3_log
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// defaultBatchConcurrency bounds the PutObject calls BatchAppend keeps in flight.
+const defaultBatchConcurrency = 8
+
+// BatchAppendMode selects how BatchAppend reacts to a failed upload.
+type BatchAppendMode int
+
+const (
+	// FailFast cancels the remaining uploads on the first failure.
+	FailFast BatchAppendMode = iota
+	// BestEffort attempts every upload and reports all failures.
+	BestEffort
+)
+
+// BatchAppend appends records with consecutive offsets and returns them in
+// input order. Uploads run concurrently; w.length only advances once the
+// records are stored.
+//
+// With FailFast the remaining uploads are cancelled on a failure: the returned
+// offsets are those before it, the length advances to the last of them and
+// the error describes it. Records stored past the failure are overwritten by
+// later appends. With BestEffort all uploads run: the returned offsets are all
+// stored ones, the length advances to the highest of them and the error is a
+// *BatchAppendError listing every failure. The failed offsets are left as
+// holes, which AppendAt can fill and which Follow skips after a grace period.
+//
+// In segment mode the records are buffered like individual Appends.
+func (w *S3WAL) BatchAppend(ctx context.Context, records [][]byte, mode BatchAppendMode) ([]uint64, error) {
+	return w.BatchAppendWithOptions(ctx, records, mode, BatchAppendOptions{})
+}
+
+// BatchAppendOptions tunes BatchAppendWithOptions.
+type BatchAppendOptions struct {
+	// Concurrency bounds the uploads in flight; 0 means
+	// defaultBatchConcurrency.
+	Concurrency int
+	// ProgressFn, if set, is called after each record finished uploading,
+	// stored or failed, with the number finished so far and the number of
+	// records, e.g. to draw a progress bar; records FailFast skipped are not
+	// reported. Calls are serialized and done only grows. It runs while the
+	// S3WAL is locked, so it must not call it.
+	ProgressFn func(done, total int)
+}
+
+// BatchAppendWithOptions is BatchAppend with a tunable concurrency and
+// progress reporting for large imports.
+func (w *S3WAL) BatchAppendWithOptions(ctx context.Context, records [][]byte, mode BatchAppendMode, opts BatchAppendOptions) ([]uint64, error) {
+	if opts.Concurrency < 0 {
+		return nil, fmt.Errorf("s3_log: invalid batch concurrency %d", opts.Concurrency)
+	}
+	if w.allocator != nil {
+		return nil, errors.New("s3_log: BatchAppend cannot be used with WithOffsetAllocator")
+	}
+	concurrency := opts.Concurrency
+	if concurrency == 0 {
+		concurrency = defaultBatchConcurrency
+	}
+	var progressMu sync.Mutex
+	done := 0
+	progress := func() {
+		if opts.ProgressFn == nil {
+			return
+		}
+		progressMu.Lock()
+		defer progressMu.Unlock()
+		done++
+		opts.ProgressFn(done, len(records))
+	}
+
+	records, err := w.applyAppendMiddlewareAll(ctx, records)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := w.beginOp(); err != nil {
+		return nil, err
+	}
+	defer w.endOp()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	first := w.length + 1
+	offsets := make([]uint64, 0, len(records))
+	if w.segment != nil {
+		for i, data := range records {
+			if err := w.appendToSegment(ctx, first+uint64(i), data); err != nil {
+				return offsets, err
+			}
+			offsets = append(offsets, first+uint64(i))
+			progress()
+		}
+		return offsets, nil
+	}
+
+	if w.dates != nil {
+		w.dates.startAt(first, time.Now())
+	}
+	bodies := make([][]byte, len(records))
+	for i, data := range records {
+		body, err := w.encodeBody(first+uint64(i), data)
+		if err != nil {
+			return nil, fmt.Errorf("prepare body: %w", err)
+		}
+		bodies[i] = body
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// errs marks which uploads did not complete; failErr is the first real
+	// failure, as opposed to the cancellations FailFast triggers
+	errs := make([]error, len(bodies))
+	var failErr error
+	var failOnce sync.Once
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i := range bodies {
+		sem <- struct{}{}
+		if ctx.Err() != nil {
+			errs[i] = ctx.Err()
+			<-sem
+			continue
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			defer progress()
+			offset := first + uint64(i)
+			input := &s3.PutObjectInput{
+				Bucket:              aws.String(w.bucketName),
+				ACL:                 w.acl,
+				ExpectedBucketOwner: w.bucketOwner(),
+				RequestPayer:        w.requestPayer,
+				Key:                 aws.String(w.getObjectKey(offset)),
+				Body:                bytes.NewReader(bodies[i]),
+				ChecksumAlgorithm:   w.checksumAlgorithm,
+			}
+			_, err := w.client.PutObject(ctx, input, s3Options(ctx)...)
+			if err != nil {
+				err = fmt.Errorf("put object (offset=%d): %w", offset, err)
+			} else {
+				err = w.verifyVisible(ctx, *input.Key)
+			}
+			if err != nil {
+				errs[i] = err
+				failOnce.Do(func() {
+					failErr = errs[i]
+					if mode == FailFast {
+						cancel()
+					}
+				})
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	w.recoveredAt = time.Time{}
+	w.length = first + uint64(len(bodies)) - 1
+	if mode == BestEffort {
+		// up to the last stored record, leaving holes at failed ones
+		for i := len(errs) - 1; i >= 0 && errs[i] != nil; i-- {
+			w.length--
+		}
+	} else {
+		for i, err := range errs {
+			if err != nil {
+				w.length = first + uint64(i) - 1
+				if failErr == nil {
+					// cancelled by the caller rather than by a failure
+					failErr = err
+				}
+				break
+			}
+		}
+	}
+
+	if mode == BestEffort {
+		batchErr := &BatchAppendError{Failed: make(map[uint64]error)}
+		for i, err := range errs {
+			if err != nil {
+				batchErr.Failed[first+uint64(i)] = err
+			} else {
+				offsets = append(offsets, first+uint64(i))
+			}
+		}
+		if len(batchErr.Failed) > 0 {
+			return offsets, batchErr
+		}
+		return offsets, nil
+	}
+
+	for offset := first; offset <= w.length; offset++ {
+		offsets = append(offsets, offset)
+	}
+	return offsets, failErr
+}
@@ -0,0 +1,68 @@
+package s3_log
+
+import (
+	"errors"
+	"testing"
+)
+
+// A BestEffort BatchAppend keeps the records stored after a failure: the
+// length moves past them, so the next append does not overwrite them, and
+// the failed offset is left as a hole.
+func TestBatchAppendBestEffortKeepsRecordsAfterFailure(t *testing.T) {
+	f, client := newFake(t)
+	w := NewS3WAL(client, "bucket", "wal")
+	defer w.Close()
+	f.denyPut = map[string]bool{w.getObjectKey(2): true}
+
+	records := [][]byte{[]byte("a"), []byte("b"), []byte("c")}
+	offsets, err := w.BatchAppend(bg, records, BestEffort)
+	var batchErr *BatchAppendError
+	if !errors.As(err, &batchErr) || len(batchErr.Failed) != 1 || batchErr.Failed[2] == nil {
+		t.Fatalf("BatchAppend error = %v, want offset 2 failed", err)
+	}
+	if len(offsets) != 2 || offsets[0] != 1 || offsets[1] != 3 {
+		t.Fatalf("stored offsets = %v, want [1 3]", offsets)
+	}
+	if length := w.Length(); length != 3 {
+		t.Fatalf("Length = %d, want 3", length)
+	}
+
+	offset, err := w.Append(bg, []byte("d"))
+	if err != nil || offset != 4 {
+		t.Fatalf("Append = %d, %v, want offset 4", offset, err)
+	}
+	rec, err := w.Read(bg, 3)
+	if err != nil || string(rec.Data) != "c" {
+		t.Fatalf("Read(3) = %q, %v, want the batch's record", rec.Data, err)
+	}
+
+	// the hole can be filled afterwards
+	delete(f.denyPut, w.getObjectKey(2))
+	if err := w.AppendAt(bg, 2, []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	if length := w.Length(); length != 4 {
+		t.Fatalf("Length after filling the hole = %d, want 4", length)
+	}
+}
+
+// A FailFast BatchAppend reports and keeps only the records before the
+// failure.
+func TestBatchAppendFailFastStopsAtFailure(t *testing.T) {
+	f, client := newFake(t)
+	w := NewS3WAL(client, "bucket", "wal")
+	defer w.Close()
+	f.denyPut = map[string]bool{w.getObjectKey(2): true}
+
+	records := [][]byte{[]byte("a"), []byte("b"), []byte("c")}
+	offsets, err := w.BatchAppendWithOptions(bg, records, FailFast, BatchAppendOptions{Concurrency: 1})
+	if err == nil {
+		t.Fatal("BatchAppend succeeded")
+	}
+	if len(offsets) != 1 || offsets[0] != 1 {
+		t.Fatalf("stored offsets = %v, want [1]", offsets)
+	}
+	if length := w.Length(); length != 1 {
+		t.Fatalf("Length = %d, want 1", length)
+	}
+}
@@ -0,0 +1,154 @@
+package s3_log
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// BufferedWriter collects records in memory and appends them with one
+// BatchAppend once maxBytes of record data are buffered or every
+// flushInterval, like a Kafka producer, cutting the request overhead of many
+// small writes. Records get consecutive offsets in the order they were
+// written. Unlike WithSegmentation each record is still its own object.
+//
+// A buffered record is lost if the process dies before it is flushed, so
+// wait for its offset before treating it as durable.
+type BufferedWriter struct {
+	wal      *S3WAL
+	maxBytes int
+
+	flushMu sync.Mutex // serializes flushes, so offsets follow write order
+
+	mu      sync.Mutex // protects the fields below
+	records [][]byte
+	waiters []chan uint64
+	size    int
+	err     error // last background flush failure, reported by Flush
+	closed  bool
+
+	kick      chan struct{} // asks the flusher to flush now
+	interval  time.Duration
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewBufferedWriter starts a BufferedWriter appending to wal. It flushes when
+// maxBytes of record data are buffered and every flushInterval; a
+// flushInterval of 0 disables the timer. Call Close to stop it.
+func NewBufferedWriter(wal *S3WAL, maxBytes int, flushInterval time.Duration) *BufferedWriter {
+	if maxBytes <= 0 || flushInterval < 0 {
+		panic(fmt.Sprintf("s3_log: invalid buffered writer %d bytes, %v", maxBytes, flushInterval))
+	}
+	b := &BufferedWriter{
+		wal:      wal,
+		maxBytes: maxBytes,
+		kick:     make(chan struct{}, 1),
+		interval: flushInterval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+// Write buffers a copy of data. The returned channel receives the record's
+// offset once it is stored and is then closed; it is closed without a value
+// if the flush failed or the writer is closed, and the error is reported by
+// the next Flush or Close.
+func (b *BufferedWriter) Write(data []byte) <-chan uint64 {
+	ch := make(chan uint64, 1)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(ch)
+		return ch
+	}
+	b.records = append(b.records, append([]byte(nil), data...))
+	b.waiters = append(b.waiters, ch)
+	b.size += len(data)
+	if b.size >= b.maxBytes {
+		select {
+		case b.kick <- struct{}{}:
+		default:
+		}
+	}
+	return ch
+}
+
+// Flush appends the buffered records and waits for them to be stored. It
+// returns the error of this flush, or else of the last failed background one.
+func (b *BufferedWriter) Flush() error {
+	err := b.flush()
+	b.mu.Lock()
+	if err == nil {
+		err = b.err
+	}
+	b.err = nil
+	b.mu.Unlock()
+	return err
+}
+
+// Close stops the timer, flushes the buffered records and makes later Writes
+// fail. It is safe to call more than once.
+func (b *BufferedWriter) Close() error {
+	b.closeOnce.Do(func() {
+		close(b.stop)
+		<-b.done
+		b.mu.Lock()
+		b.closed = true
+		b.mu.Unlock()
+	})
+	return b.Flush()
+}
+
+// run flushes on the timer and whenever Write fills the buffer, until Close.
+func (b *BufferedWriter) run() {
+	defer close(b.done)
+	var tick <-chan time.Time
+	if b.interval > 0 {
+		ticker := time.NewTicker(b.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-b.kick:
+		case <-tick:
+		}
+		if err := b.flush(); err != nil {
+			b.mu.Lock()
+			b.err = err
+			b.mu.Unlock()
+		}
+	}
+}
+
+// flush appends the records buffered so far and hands out their offsets.
+func (b *BufferedWriter) flush() error {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+	b.mu.Lock()
+	records, waiters := b.records, b.waiters
+	b.records, b.waiters, b.size = nil, nil, 0
+	b.mu.Unlock()
+	if len(records) == 0 {
+		return nil
+	}
+
+	offsets, err := b.wal.BatchAppend(context.Background(), records, FailFast)
+	for i, ch := range waiters {
+		if i < len(offsets) {
+			ch <- offsets[i]
+		}
+		close(ch)
+	}
+	if err != nil {
+		return fmt.Errorf("buffered flush of %d records: %w", len(records), err)
+	}
+	return nil
+}
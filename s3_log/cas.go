@@ -0,0 +1,102 @@
+package s3_log
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// CompareAndAppend appends data only if the head of the WAL, as returned by
+// ChainHead, is expectedHead, and returns the new offset. Several writers can
+// share one WAL this way without a lock: each appends after the head it last
+// saw, and the one that lost a race gets a *CASError wrapping ErrCASFailed
+// with the new head, reads the records it missed and tries again. The record
+// is written with a conditional PutObject that fails if its offset was taken,
+// so two writers expecting the same head never both succeed. Under
+// WithHashChain the record carries expectedHead as its PrevHash.
+//
+// The head is found from this S3WAL's length, moving past records other
+// writers stored since, so call Recover once before the first call. Records
+// are not buffered, so it cannot be used with WithSegmentation, nor with
+// WithDatePartitioning.
+func (w *S3WAL) CompareAndAppend(ctx context.Context, expectedHead []byte, data []byte) (uint64, error) {
+	if err := w.beginOp(); err != nil {
+		return 0, err
+	}
+	defer w.endOp()
+	if w.segment != nil {
+		return 0, errors.New("s3_log: CompareAndAppend cannot be used with WithSegmentation")
+	}
+	if w.dates != nil {
+		// writers on different days would not contend for the same key
+		return 0, errors.New("s3_log: CompareAndAppend cannot be used with WithDatePartitioning")
+	}
+	if w.allocator != nil {
+		return 0, errors.New("s3_log: CompareAndAppend cannot be used with WithOffsetAllocator")
+	}
+	data, err := w.applyAppendMiddleware(ctx, data)
+	if err != nil {
+		return 0, err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// catch up with records other writers appended
+	for w.objectExists(ctx, w.getObjectKey(w.length+1)) {
+		w.length++
+		w.recoveredAt = time.Time{}
+	}
+	next := w.length + 1
+	head, err := w.chainPrev(ctx, next)
+	if err != nil {
+		return 0, err
+	}
+	if !bytes.Equal(head, expectedHead) {
+		return 0, &CASError{Head: append([]byte(nil), head...), Offset: w.length}
+	}
+
+	var prev []byte
+	if w.hashChain {
+		prev = head
+	}
+	body, err := w.codec.Marshal(Record{Offset: next, Data: data, PrevHash: prev})
+	if err != nil {
+		return 0, fmt.Errorf("prepare body: %w", err)
+	}
+	input := &s3.PutObjectInput{
+		Bucket:              aws.String(w.bucketName),
+		ACL:                 w.acl,
+		ExpectedBucketOwner: w.bucketOwner(),
+		RequestPayer:        w.requestPayer,
+		Key:                 aws.String(w.getObjectKey(next)),
+		Body:                bytes.NewReader(body),
+		ChecksumAlgorithm:   w.checksumAlgorithm,
+		IfNoneMatch:         aws.String("*"),
+	}
+	if _, err := w.client.PutObject(ctx, input, s3Options(ctx)...); err != nil {
+		if !isPreconditionFailed(err) {
+			return 0, fmt.Errorf("put object (offset=%d): %w", next, err)
+		}
+		// another writer took the offset: its record is the new head
+		w.length = next
+		w.recoveredAt = time.Time{}
+		head, err := w.chainPrev(ctx, next+1)
+		if err != nil {
+			return 0, err
+		}
+		return 0, &CASError{Head: append([]byte(nil), head...), Offset: next}
+	}
+	if err := w.verifyVisible(ctx, *input.Key); err != nil {
+		return 0, err
+	}
+
+	w.length = next
+	w.recoveredAt = time.Time{}
+	w.setChainTail(next, chainHash(body))
+	return next, nil
+}
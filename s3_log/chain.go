@@ -0,0 +1,145 @@
+package s3_log
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// chainHash is the hash a record's successor stores as its PrevHash.
+func chainHash(body []byte) []byte {
+	sum := sha256.Sum256(body)
+	return sum[:]
+}
+
+// chainPrev returns the PrevHash of the record to be written at offset: the
+// hash of the body at offset-1, from the cache if that is the record written
+// last, or all zeros for the first record. Callers must hold w.mu.
+func (w *S3WAL) chainPrev(ctx context.Context, offset uint64) ([]byte, error) {
+	if offset == 1 {
+		return make([]byte, sha256.Size), nil
+	}
+	if w.chainOffset == offset-1 && w.chainHash != nil {
+		return w.chainHash, nil
+	}
+	body, err := w.readRaw(ctx, offset-1)
+	if err != nil {
+		return nil, fmt.Errorf("hash chain: read offset %d: %w", offset-1, err)
+	}
+	w.setChainTail(offset-1, chainHash(body))
+	return w.chainHash, nil
+}
+
+// setChainTail caches the hash of the body stored at offset. Callers must
+// hold w.mu.
+func (w *S3WAL) setChainTail(offset uint64, hash []byte) {
+	w.chainHash, w.chainOffset = hash, offset
+}
+
+// VerifyChain checks the WithHashChain links of the stored records from
+// offset from to offset to, both inclusive: every record must carry the hash
+// of the body of the record before it, the first record of the WAL a hash of
+// zeros. It returns an error wrapping ErrChainBroken at the first record that
+// does not, including records written without WithHashChain and missing
+// ones, and ErrOffsetNotFound if the WAL ends before to. Buffered records are
+// not checked.
+func (w *S3WAL) VerifyChain(ctx context.Context, from, to uint64) error {
+	if from == 0 || to < from {
+		return fmt.Errorf("s3_log: invalid chain range %d-%d", from, to)
+	}
+	if err := w.beginOp(); err != nil {
+		return err
+	}
+	defer w.endOp()
+
+	// the record at from-1 is read for its hash but not checked itself
+	var prev []byte
+	if from == 1 {
+		prev = make([]byte, sha256.Size)
+	}
+	next := from - 1
+	err := w.walkObjects(ctx, func(obj types.Object, first, last uint64) error {
+		if last+1 < from {
+			return nil
+		}
+		if first > to {
+			return errStopWalk
+		}
+		key := aws.ToString(obj.Key)
+		data, err := w.getBody(ctx, key)
+		if err != nil {
+			return err
+		}
+		bodies := [][]byte{data}
+		if isSegment(data) {
+			if bodies, err = segmentBodies(data); err != nil {
+				return fmt.Errorf("parse segment %s: %w", key, err)
+			}
+			if uint64(len(bodies)) != last-first+1 {
+				return fmt.Errorf("segment %s holds %d records, key says %d", key, len(bodies), last-first+1)
+			}
+		}
+		for i, body := range bodies {
+			offset := first + uint64(i)
+			if offset+1 < from {
+				continue
+			}
+			if offset > to {
+				return errStopWalk
+			}
+			if offset >= from {
+				if offset != next+1 || prev == nil {
+					return fmt.Errorf("offset %d: %w: offset %d is missing", offset, ErrChainBroken, next+1)
+				}
+				rec, err := w.decodeRecord(key, offset, body)
+				if err != nil {
+					return err
+				}
+				if !bytes.Equal(rec.PrevHash, prev) {
+					return fmt.Errorf("offset %d: %w", offset, ErrChainBroken)
+				}
+			}
+			prev, next = chainHash(body), offset
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errStopWalk) {
+		return err
+	}
+	if next < to {
+		return fmt.Errorf("offset %d: %w", next+1, ErrOffsetNotFound)
+	}
+	return nil
+}
+
+// ChainHead returns the hash of the last stored record and its offset, the
+// value the WithHashChain record after it will carry. Publish it to a place
+// the WAL's writers cannot change, such as another bucket or an audit log.
+// An auditor later checks that the SHA-256 of the record's ReadRaw body still
+// equals the published hash and that VerifyChain up to the offset succeeds,
+// which proves no record up to it was edited since. The head is the length
+// known to this S3WAL, so call Recover first for writes by others; buffered
+// records are not included. An empty WAL has a head of zeros at offset 0.
+func (w *S3WAL) ChainHead(ctx context.Context) ([]byte, uint64, error) {
+	if err := w.beginOp(); err != nil {
+		return nil, 0, err
+	}
+	defer w.endOp()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	offset := w.length
+	if len(w.pending) > 0 {
+		offset = w.pending[0].Offset - 1
+	}
+	hash, err := w.chainPrev(ctx, offset+1)
+	if err != nil {
+		return nil, 0, err
+	}
+	return append([]byte(nil), hash...), offset, nil
+}
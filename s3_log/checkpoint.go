@@ -0,0 +1,72 @@
+package s3_log
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// SaveCheckpoint stores offset as the position of the consumer called name,
+// so that consumers can track independent positions in the WAL itself. It
+// overwrites the previous checkpoint of name.
+func (w *S3WAL) SaveCheckpoint(ctx context.Context, name string, offset uint64) error {
+	if name == "" {
+		return errors.New("s3_log: empty checkpoint name")
+	}
+	key := w.consumerCheckpointKey(name)
+	_, err := w.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:              aws.String(w.bucketName),
+		ACL:                 w.acl,
+		ExpectedBucketOwner: w.bucketOwner(),
+		RequestPayer:        w.requestPayer,
+		Key:                 aws.String(key),
+		Body:                bytes.NewReader([]byte(strconv.FormatUint(offset, 10))),
+	}, s3Options(ctx)...)
+	if err != nil {
+		return fmt.Errorf("put checkpoint %s: %w", key, err)
+	}
+	return nil
+}
+
+// LoadCheckpoint returns the offset last saved for the consumer called name,
+// or 0 if none was saved.
+func (w *S3WAL) LoadCheckpoint(ctx context.Context, name string) (uint64, error) {
+	key := w.consumerCheckpointKey(name)
+	out, err := w.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket:              aws.String(w.bucketName),
+		ExpectedBucketOwner: w.bucketOwner(),
+		RequestPayer:        w.requestPayer,
+		Key:                 aws.String(key),
+	}, s3Options(ctx)...)
+	if err != nil {
+		if isNotFound(err) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("get checkpoint %s: %w", key, err)
+	}
+	defer out.Body.Close()
+
+	data, err := io.ReadAll(out.Body)
+	if err != nil {
+		return 0, fmt.Errorf("read checkpoint %s: %w", key, err)
+	}
+	offset, err := strconv.ParseUint(string(data), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parse checkpoint %s: %w", key, err)
+	}
+	return offset, nil
+}
+
+// consumerCheckpointKey is the object holding the checkpoint of the consumer
+// called name. Like the replication checkpoint it never parses as an offset,
+// so scans skip it.
+func (w *S3WAL) consumerCheckpointKey(name string) string {
+	return w.listPrefix() + "_checkpoints/" + url.PathEscape(name)
+}
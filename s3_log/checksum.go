@@ -0,0 +1,33 @@
+package s3_log
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// AppendWithChecksum is Append that also returns the SHA-256 stored in the
+// record's body, e.g. to build an external index without hashing the data
+// again. The checksum covers the offset and the stored form of the data, so
+// equal data appended at two offsets has two checksums. It is nil under
+// WithoutBodyChecksum or WithRecordCodec, whose bodies carry none.
+//
+// Records buffered by WithSegmentation are encoded only when flushed, so it
+// cannot be used with WithSegmentation.
+func (w *S3WAL) AppendWithChecksum(ctx context.Context, data []byte) (uint64, []byte, error) {
+	if w.segment != nil {
+		return 0, nil, errors.New("s3_log: AppendWithChecksum cannot be used with WithSegmentation")
+	}
+	offset, body, err := w.append(ctx, data, 0, true)
+	if err != nil {
+		return 0, nil, err
+	}
+	if _, ok := w.codec.(checksumCodec); !ok {
+		return offset, nil, nil
+	}
+	sum, err := bodyChecksum(body)
+	if err != nil {
+		return offset, nil, fmt.Errorf("offset %d: %w", offset, err)
+	}
+	return offset, append([]byte(nil), sum...), nil
+}
@@ -0,0 +1,165 @@
+package s3_log
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// Coalesce packs records stored one object per record into segment objects
+// of groupSize records each, keeping their offsets, and then deletes the
+// originals, to cut the object count of a log written without
+// WithSegmentation. Only runs of groupSize consecutive plain records are
+// packed; the rest, such as the last records of the log, stay as they are
+// until a later Coalesce finds a full run. Record bodies are copied as
+// stored, so compression, encryption and WithHashChain links are kept, and
+// the newest LastModified time of the packed records is saved with the
+// segment, so that TruncateBeforeTime still sees them at their age.
+//
+// It is resumable: a record is only deleted once a segment holding it was
+// stored, and Read finds it in either object meanwhile. A Coalesce that was
+// interrupted deletes such leftover records first. The keys of the WAL are
+// listed into memory. Appends may run meanwhile, but no Truncate, Overwrite
+// or AppendAt by any writer.
+func (w *S3WAL) Coalesce(ctx context.Context, groupSize int) error {
+	if groupSize < 2 {
+		return fmt.Errorf("s3_log: invalid group size %d", groupSize)
+	}
+	if err := w.beginOp(); err != nil {
+		return err
+	}
+	defer w.endOp()
+
+	if w.dates != nil {
+		if err := w.loadDatePartitions(ctx); err != nil {
+			return err
+		}
+	}
+
+	type span struct{ first, last uint64 }
+	var segments []span
+	var plain []uint64
+	modified := make(map[uint64]time.Time)
+	err := w.eachObject(ctx, func(obj types.Object, first, last uint64) error {
+		if first == last && aws.ToString(obj.Key) == w.getObjectKey(first) {
+			plain = append(plain, first)
+			modified[first] = aws.ToTime(obj.LastModified)
+		} else {
+			segments = append(segments, span{first, last})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i].first < segments[j].first })
+	sort.Slice(plain, func(i, j int) bool { return plain[i] < plain[j] })
+
+	// plain records a segment already holds are left over from an
+	// interrupted Coalesce
+	var leftover []types.ObjectIdentifier
+	var run []uint64
+	s := 0
+	flushRun := func() error {
+		for len(run) >= groupSize {
+			var written time.Time
+			for _, offset := range run[:groupSize] {
+				if modified[offset].After(written) {
+					written = modified[offset]
+				}
+			}
+			if err := w.coalesceRun(ctx, run[:groupSize], written); err != nil {
+				return err
+			}
+			run = run[groupSize:]
+		}
+		run = run[:0]
+		return nil
+	}
+	for _, offset := range plain {
+		for s < len(segments) && segments[s].last < offset {
+			s++
+		}
+		if s < len(segments) && segments[s].first <= offset {
+			leftover = append(leftover, types.ObjectIdentifier{Key: aws.String(w.getObjectKey(offset))})
+			continue
+		}
+		// a segment must not span WithDatePartitioning partitions
+		if len(run) > 0 && (run[len(run)-1]+1 != offset || w.dates != nil && w.dates.dir(offset) != w.dates.dir(run[len(run)-1])) {
+			if err := flushRun(); err != nil {
+				return err
+			}
+		}
+		run = append(run, offset)
+	}
+	if err := w.deleteKeys(ctx, leftover); err != nil {
+		return err
+	}
+	return flushRun()
+}
+
+// coalesceRun packs the plain records at offsets, which are consecutive, into
+// one segment saying they were written at written, and deletes them.
+func (w *S3WAL) coalesceRun(ctx context.Context, offsets []uint64, written time.Time) error {
+	first, last := offsets[0], offsets[len(offsets)-1]
+	bodies := make([][]byte, len(offsets))
+	keys := make([]types.ObjectIdentifier, len(offsets))
+	for i, offset := range offsets {
+		key := w.getObjectKey(offset)
+		body, err := w.getBody(ctx, key)
+		if err != nil {
+			return err
+		}
+		// do not pack a corrupt record where it would be harder to repair
+		if _, err := w.decodeRecord(key, offset, body); err != nil {
+			return err
+		}
+		bodies[i] = body
+		keys[i] = types.ObjectIdentifier{Key: aws.String(key)}
+	}
+	if err := w.putSegmentBody(ctx, first, last, packSegment(bodies), written); err != nil {
+		return err
+	}
+	return w.deleteKeys(ctx, keys)
+}
+
+// coalescedMetaKey is the user metadata of a Coalesce segment holding the
+// newest LastModified time of the records packed into it.
+const coalescedMetaKey = "coalesced-written"
+
+// coalescedAt returns the time the records of the segment at key were
+// written, as saved by Coalesce, or modified, its LastModified time, for a
+// segment stored otherwise.
+func (w *S3WAL) coalescedAt(ctx context.Context, key string, modified time.Time) (time.Time, error) {
+	head, err := w.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:              aws.String(w.bucketName),
+		ExpectedBucketOwner: w.bucketOwner(),
+		RequestPayer:        w.requestPayer,
+		Key:                 aws.String(key),
+	}, s3Options(ctx)...)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("head segment %s: %w", key, err)
+	}
+	written, err := time.Parse(time.RFC3339Nano, head.Metadata[coalescedMetaKey])
+	if err != nil {
+		return modified, nil
+	}
+	return written, nil
+}
+
+// deleteKeys deletes keys in batches of 1000, the DeleteObjects limit.
+func (w *S3WAL) deleteKeys(ctx context.Context, keys []types.ObjectIdentifier) error {
+	for len(keys) > 0 {
+		n := min(len(keys), 1000)
+		if err := w.batchDelete(ctx, keys[:n]); err != nil {
+			return err
+		}
+		keys = keys[n:]
+	}
+	return nil
+}
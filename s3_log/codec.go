@@ -0,0 +1,286 @@
+package s3_log
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+)
+
+// RecordCodec turns a record into the body of its S3 object and back. The
+// body must carry the offset so that reads can detect a record stored under
+// the wrong key. Segments hold one codec body per record.
+type RecordCodec interface {
+	Marshal(rec Record) ([]byte, error)
+	Unmarshal(body []byte) (Record, error)
+}
+
+// ChecksumPlacement selects where the SHA-256 of a record body is stored.
+type ChecksumPlacement int
+
+const (
+	// ChecksumTrailer stores the checksum after the data, the original layout:
+	// [8-byte offset BE][data][32-byte sha256].
+	ChecksumTrailer ChecksumPlacement = iota
+	// ChecksumHeader stores it before the data so a reader can verify the data
+	// while streaming it: [format][flags][8-byte offset BE][32-byte sha256][data].
+	ChecksumHeader
+)
+
+// Bodies in a versioned layout start with a format byte followed by a flags
+// byte. The original layout has neither and starts with the big-endian offset,
+// whose first byte is 0 for any offset below 2^56, so format bytes are nonzero.
+//
+// A formatV1 body is
+//
+//	[format][flags][8-byte offset BE][compression header][nonce header][prev hash][sha256 if header][payload][sha256 if trailer]
+//
+// where the compression header ([1-byte compression id][4-byte dictionary id BE])
+// is present only if flagCompressed is set, the nonce header ([1-byte nonce
+// length][nonce]) only if flagEncrypted is set, the 32-byte SHA-256 of the
+// previous record's body (WithHashChain) only if flagChained is set, and the
+// checksum covers every other byte of the body. An encrypted payload is
+// sealed with everything before the checksum as additional data.
+const (
+	formatV1 byte = 0x01
+
+	flagChecksumHeader byte = 1 << 0
+	flagCompressed     byte = 1 << 1
+	flagEncrypted      byte = 1 << 2
+	flagChained        byte = 1 << 3
+
+	knownFlags = flagChecksumHeader | flagCompressed | flagEncrypted | flagChained
+)
+
+// checksumCodec is the default codec. Bodies are sha256-checked and either
+// placement can be read regardless of the one it writes. Records are
+// compressed when zstd is set, compressIf accepts them and it makes them
+// smaller, then encrypted when aead is set.
+type checksumCodec struct {
+	placement  ChecksumPlacement
+	zstd       *zstdCompression
+	compressIf func(data []byte) bool // nil means defaultCompressIf
+	aead       cipher.AEAD
+}
+
+// compressMinSize is the size below which records are not worth compressing
+// by default: the zstd frame overhead eats most of the gain.
+const compressMinSize = 32
+
+func defaultCompressIf(data []byte) bool {
+	return len(data) >= compressMinSize
+}
+
+func (c checksumCodec) Marshal(rec Record) ([]byte, error) {
+	var flags byte
+	var ext []byte // header fields after the offset
+	payload := rec.Data
+	compressIf := c.compressIf
+	if compressIf == nil {
+		compressIf = defaultCompressIf
+	}
+	if c.zstd != nil && compressIf(rec.Data) {
+		if compressed, ok := c.zstd.compress(rec.Data); ok {
+			flags |= flagCompressed
+			ext = binary.BigEndian.AppendUint32(append(ext, compressionZstd), c.zstd.dictID)
+			payload = compressed
+		}
+	}
+	var nonce []byte
+	if c.aead != nil {
+		flags |= flagEncrypted
+		nonce = make([]byte, c.aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, fmt.Errorf("generate nonce: %w", err)
+		}
+		ext = append(append(ext, byte(len(nonce))), nonce...)
+	}
+	if rec.PrevHash != nil {
+		if len(rec.PrevHash) != sha256.Size {
+			return nil, fmt.Errorf("previous record hash is %d bytes, want %d", len(rec.PrevHash), sha256.Size)
+		}
+		flags |= flagChained
+		ext = append(ext, rec.PrevHash...)
+	}
+	if c.placement == ChecksumHeader {
+		flags |= flagChecksumHeader
+	}
+	if flags == 0 {
+		// nothing needs the versioned layout, keep the original one
+		return prepareBody(rec.Offset, rec.Data)
+	}
+
+	header := make([]byte, 10, 10+len(ext))
+	header[0] = formatV1
+	header[1] = flags
+	binary.BigEndian.PutUint64(header[2:], rec.Offset)
+	header = append(header, ext...)
+	if c.aead != nil {
+		// sealing the header too binds the ciphertext to its offset
+		payload = c.aead.Seal(nil, nonce, payload, header)
+	}
+	return prepareV1Body(header, payload), nil
+}
+
+func (c checksumCodec) Unmarshal(body []byte) (Record, error) {
+	if len(body) > 0 && body[0] == formatV1 {
+		return c.decodeV1Body(body)
+	}
+	if len(body) < 8+sha256.Size {
+		return Record{}, ErrRecordTooShort
+	}
+	offset := binary.BigEndian.Uint64(body[:8])
+	if !validateChecksum(body) {
+		return Record{}, fmt.Errorf("offset %d: %w", offset, ErrChecksumMismatch)
+	}
+	data := make([]byte, len(body)-8-sha256.Size)
+	copy(data, body[8:len(body)-sha256.Size])
+	return Record{Offset: offset, Data: data}, nil
+}
+
+// isNativeBody reports whether body starts like one of checksumCodec's
+// layouts: a format byte, or the zero high byte of an original layout offset.
+func isNativeBody(body []byte) bool {
+	return len(body) > 0 && (body[0] == formatV1 || body[0] == 0)
+}
+
+// prepareV1Body writes a formatV1 body from its header, up to and including
+// the nonce header, and payload.
+func prepareV1Body(header, payload []byte) []byte {
+	flags := header[1]
+	hasher := sha256.New()
+	hasher.Write(header)
+	hasher.Write(payload)
+	sum := hasher.Sum(nil)
+
+	body := make([]byte, 0, len(header)+sha256.Size+len(payload))
+	body = append(body, header...)
+	if flags&flagChecksumHeader != 0 {
+		return append(append(body, sum...), payload...)
+	}
+	return append(append(body, payload...), sum...)
+}
+
+// decodeV1Body parses, validates, decrypts and decompresses a formatV1 body.
+func (c checksumCodec) decodeV1Body(body []byte) (Record, error) {
+	if len(body) < 10 {
+		return Record{}, ErrRecordTooShort
+	}
+	flags := body[1]
+	if flags&^knownFlags != 0 {
+		return Record{}, fmt.Errorf("unsupported record flags %#x", flags)
+	}
+	offset := binary.BigEndian.Uint64(body[2:10])
+	headerLen, nonceStart, nonceLen, err := v1HeaderLen(body)
+	if err != nil {
+		return Record{}, err
+	}
+
+	header := body[:headerLen]
+	var sum, payload []byte
+	if flags&flagChecksumHeader != 0 {
+		sum, payload = body[headerLen:headerLen+sha256.Size], body[headerLen+sha256.Size:]
+	} else {
+		payload, sum = body[headerLen:len(body)-sha256.Size], body[len(body)-sha256.Size:]
+	}
+	hasher := sha256.New()
+	hasher.Write(header)
+	hasher.Write(payload)
+	if !bytes.Equal(hasher.Sum(nil), sum) {
+		return Record{}, fmt.Errorf("offset %d: %w", offset, ErrChecksumMismatch)
+	}
+
+	if flags&flagEncrypted != 0 {
+		if c.aead == nil {
+			return Record{}, fmt.Errorf("offset %d is encrypted, see WithClientEncryption", offset)
+		}
+		nonce := header[nonceStart : nonceStart+nonceLen]
+		if len(nonce) != c.aead.NonceSize() {
+			return Record{}, fmt.Errorf("decrypt offset %d: nonce is %d bytes, cipher expects %d", offset, len(nonce), c.aead.NonceSize())
+		}
+		plain, err := c.aead.Open(nil, nonce, payload, header)
+		if err != nil {
+			return Record{}, fmt.Errorf("decrypt offset %d: %w", offset, err)
+		}
+		payload = plain
+	}
+
+	var prevHash []byte
+	if flags&flagChained != 0 {
+		prevHash = append([]byte(nil), header[headerLen-sha256.Size:]...)
+	}
+	if flags&flagCompressed != 0 {
+		data, err := c.zstd.decompress(header[10], binary.BigEndian.Uint32(header[11:15]), payload)
+		if err != nil {
+			return Record{}, fmt.Errorf("decompress offset %d: %w", offset, err)
+		}
+		return Record{Offset: offset, Data: data, PrevHash: prevHash}, nil
+	}
+	data := make([]byte, len(payload))
+	copy(data, payload)
+	return Record{Offset: offset, Data: data, PrevHash: prevHash}, nil
+}
+
+// v1HeaderLen returns the length of the header of a formatV1 body, up to
+// and including the previous record hash, and where its nonce is. It checks
+// that the body is long enough for the header and the checksum.
+func v1HeaderLen(body []byte) (headerLen, nonceStart, nonceLen int, err error) {
+	if len(body) < 10 {
+		return 0, 0, 0, ErrRecordTooShort
+	}
+	flags := body[1]
+	headerLen = 10
+	if flags&flagCompressed != 0 {
+		headerLen += 5
+	}
+	if flags&flagEncrypted != 0 {
+		if len(body) <= headerLen {
+			return 0, 0, 0, ErrRecordTooShort
+		}
+		nonceStart, nonceLen = headerLen+1, int(body[headerLen])
+		headerLen += 1 + nonceLen
+	}
+	if flags&flagChained != 0 {
+		headerLen += sha256.Size
+	}
+	if len(body) < headerLen+sha256.Size {
+		return 0, 0, 0, ErrRecordTooShort
+	}
+	return headerLen, nonceStart, nonceLen, nil
+}
+
+// bodyChecksum returns the SHA-256 stored in a body of checksumCodec, in
+// either layout and placement. It does not validate it.
+func bodyChecksum(body []byte) ([]byte, error) {
+	if len(body) > 0 && body[0] == formatV1 {
+		headerLen, _, _, err := v1HeaderLen(body)
+		if err != nil {
+			return nil, err
+		}
+		if body[1]&flagChecksumHeader != 0 {
+			return body[headerLen : headerLen+sha256.Size], nil
+		}
+	}
+	if len(body) < 8+sha256.Size {
+		return nil, ErrRecordTooShort
+	}
+	return body[len(body)-sha256.Size:], nil
+}
+
+// plainCodec is the layout without the in-body checksum: [8-byte offset BE][data].
+type plainCodec struct{}
+
+func (plainCodec) Marshal(rec Record) ([]byte, error) {
+	return preparePlainBody(rec.Offset, rec.Data), nil
+}
+
+func (plainCodec) Unmarshal(body []byte) (Record, error) {
+	if len(body) < 8 {
+		return Record{}, ErrRecordTooShort
+	}
+	data := make([]byte, len(body)-8)
+	copy(data, body[8:])
+	return Record{Offset: binary.BigEndian.Uint64(body[:8]), Data: data}, nil
+}
@@ -0,0 +1,50 @@
+package s3_log
+
+import (
+	"bytes"
+	"testing"
+)
+
+// A log written partly before and partly after enabling WithZstdCompression
+// reads back in full, with or without the option on the reader.
+func TestReadMixedCompression(t *testing.T) {
+	f, client := newFake(t)
+	data := bytes.Repeat([]byte("compressible "), 16)
+
+	plain := NewS3WAL(client, "bucket", "wal")
+	defer plain.Close()
+	if _, err := plain.Append(bg, data); err != nil {
+		t.Fatal(err)
+	}
+	compressed := NewS3WAL(client, "bucket", "wal", WithZstdCompression())
+	defer compressed.Close()
+	if _, err := compressed.Recover(bg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := compressed.Append(bg, data); err != nil {
+		t.Fatal(err)
+	}
+
+	// the log really is mixed
+	f.mu.Lock()
+	first, second := f.objects[plain.getObjectKey(1)].data, f.objects[plain.getObjectKey(2)].data
+	f.mu.Unlock()
+	if first[0] == formatV1 && first[1]&flagCompressed != 0 {
+		t.Fatal("record 1 is compressed")
+	}
+	if second[0] != formatV1 || second[1]&flagCompressed == 0 {
+		t.Fatal("record 2 is not compressed")
+	}
+
+	for name, w := range map[string]*S3WAL{"without compression": plain, "with compression": compressed} {
+		for offset := uint64(1); offset <= 2; offset++ {
+			rec, err := w.Read(bg, offset)
+			if err != nil {
+				t.Fatalf("reader %s: Read(%d): %v", name, offset, err)
+			}
+			if !bytes.Equal(rec.Data, data) {
+				t.Fatalf("reader %s: Read(%d) = %q, want the appended data", name, offset, rec.Data)
+			}
+		}
+	}
+}
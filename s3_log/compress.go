@@ -0,0 +1,145 @@
+package s3_log
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/klauspost/compress/dict"
+	"github.com/klauspost/compress/zstd"
+)
+
+// compression ids stored in the header of compressed bodies
+const compressionZstd byte = 1
+
+// zstdCompression compresses record data with zstd, optionally with a trained
+// dictionary. Both the encoder and the decoder are safe for concurrent use.
+type zstdCompression struct {
+	encoder *zstd.Encoder
+	dictID  uint32 // dictionary used for writing, 0 for none
+	decoder *zstd.Decoder
+	dicts   map[uint32][]byte // every dictionary readers accept, by id
+}
+
+// newZstdCompression builds a compressor writing with the dictionary of id
+// writeDict (0 for none) and reading with any of dicts.
+func newZstdCompression(writeDict uint32, dicts map[uint32][]byte) (*zstdCompression, error) {
+	encOpts := []zstd.EOption{zstd.WithEncoderConcurrency(1)}
+	if writeDict != 0 {
+		encOpts = append(encOpts, zstd.WithEncoderDict(dicts[writeDict]))
+	}
+	encoder, err := zstd.NewWriter(nil, encOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("create zstd encoder: %w", err)
+	}
+
+	all := make([][]byte, 0, len(dicts))
+	for _, d := range dicts {
+		all = append(all, d)
+	}
+	decoder, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(0), zstd.WithDecoderDicts(all...))
+	if err != nil {
+		return nil, fmt.Errorf("create zstd decoder: %w", err)
+	}
+	return &zstdCompression{encoder: encoder, dictID: writeDict, decoder: decoder, dicts: dicts}, nil
+}
+
+// zstdDictID validates a zstd dictionary and returns its id.
+func zstdDictID(d []byte) (uint32, error) {
+	info, err := zstd.InspectDictionary(d)
+	if err != nil {
+		return 0, err
+	}
+	if info.ID() == 0 {
+		return 0, errors.New("id 0 is reserved for no dictionary")
+	}
+	return info.ID(), nil
+}
+
+// withDict returns a compressor that also reads, and from now on writes, with
+// dictionary d of the given id.
+func (z *zstdCompression) withDict(id uint32, d []byte) (*zstdCompression, error) {
+	dicts := map[uint32][]byte{id: d}
+	if z != nil {
+		for oldID, old := range z.dicts {
+			if oldID != id {
+				dicts[oldID] = old
+			}
+		}
+	}
+	return newZstdCompression(id, dicts)
+}
+
+// compress returns data compressed, or false if that would not make it smaller.
+func (z *zstdCompression) compress(data []byte) ([]byte, bool) {
+	compressed := z.encoder.EncodeAll(data, nil)
+	if len(compressed) >= len(data) {
+		return nil, false
+	}
+	return compressed, true
+}
+
+var (
+	plainZstdOnce    sync.Once
+	plainZstdDecoder *zstd.Decoder
+)
+
+// decompress reverses compress for a body written with the given compression
+// and dictionary ids. z may be nil, which reads records compressed without a
+// dictionary only.
+func (z *zstdCompression) decompress(compression byte, dictID uint32, payload []byte) ([]byte, error) {
+	if compression != compressionZstd {
+		return nil, fmt.Errorf("unknown compression id %d", compression)
+	}
+	if dictID != 0 && (z == nil || z.dicts[dictID] == nil) {
+		return nil, fmt.Errorf("unknown zstd dictionary id %d, see WithZstdDictionary", dictID)
+	}
+	if z != nil {
+		return z.decoder.DecodeAll(payload, nil)
+	}
+	plainZstdOnce.Do(func() {
+		plainZstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+	})
+	return plainZstdDecoder.DecodeAll(payload, nil)
+}
+
+// TrainZstdDictionary builds a zstd dictionary of at most maxDictSize bytes
+// from up to sampleRecords records read from the start of the WAL, for use
+// with WithZstdDictionary. Dictionaries work best trained on a few hundred or
+// more records that are typical for the log.
+func (w *S3WAL) TrainZstdDictionary(ctx context.Context, sampleRecords, maxDictSize int) ([]byte, error) {
+	var samples [][]byte
+	err := w.walkObjects(ctx, func(obj types.Object, first, last uint64) error {
+		records, err := w.readObject(ctx, aws.ToString(obj.Key), first, last)
+		if err != nil {
+			return err
+		}
+		for _, rec := range records {
+			if len(samples) < sampleRecords {
+				samples = append(samples, rec.Data)
+			}
+		}
+		if len(samples) >= sampleRecords {
+			return errStopWalk
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errStopWalk) {
+		return nil, fmt.Errorf("sample records: %w", err)
+	}
+	if len(samples) == 0 {
+		return nil, errors.New("no records to train a dictionary from")
+	}
+
+	d, err := dict.BuildZstdDict(samples, dict.Options{
+		MaxDictSize: maxDictSize,
+		HashBytes:   6,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("build zstd dictionary: %w", err)
+	}
+	return d, nil
+}
@@ -0,0 +1,32 @@
+package s3_log
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+type s3OptionsKey struct{}
+
+// ContextWithS3Options returns a context that makes every S3 request an
+// S3WAL operation issues with it apply opts, e.g. to add request-level
+// middleware or change retry settings for one call:
+//
+//	ctx = s3_log.ContextWithS3Options(ctx, func(o *s3.Options) { o.RetryMaxAttempts = 1 })
+//	offset, err := wal.Append(ctx, data)
+//
+// Options added by nested calls are applied after the outer ones. Background
+// segment flushes do not run with the caller's context and ignore them.
+func ContextWithS3Options(ctx context.Context, opts ...func(*s3.Options)) context.Context {
+	outer := s3Options(ctx)
+	// copy so sibling contexts never share a backing array
+	all := make([]func(*s3.Options), 0, len(outer)+len(opts))
+	all = append(append(all, outer...), opts...)
+	return context.WithValue(ctx, s3OptionsKey{}, all)
+}
+
+// s3Options returns the per-request options carried by ctx.
+func s3Options(ctx context.Context) []func(*s3.Options) {
+	opts, _ := ctx.Value(s3OptionsKey{}).([]func(*s3.Options))
+	return opts
+}
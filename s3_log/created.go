@@ -0,0 +1,176 @@
+package s3_log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// createdMarkerVersion is the layout version of the _created marker.
+const createdMarkerVersion = 1
+
+// createdSampleOffset is the offset whose name the _created marker records,
+// large enough to show the padding and any WithKeyTransform.
+const createdSampleOffset = 12345
+
+// createdMarker describes the key format a WAL was created with.
+type createdMarker struct {
+	Version         int       `json:"version"`
+	Created         time.Time `json:"created"`
+	Separator       string    `json:"separator"`
+	OffsetWidth     int       `json:"offset_width"`
+	SampleName      string    `json:"sample_name"`
+	HashChars       int       `json:"hash_chars"`
+	DatePartitioned bool      `json:"date_partitioned"`
+}
+
+// currentFormat returns the marker this S3WAL's options would write.
+func (w *S3WAL) currentFormat() createdMarker {
+	return createdMarker{
+		Version:         createdMarkerVersion,
+		Separator:       w.separator,
+		OffsetWidth:     len(w.offsetName(1)),
+		SampleName:      w.offsetName(createdSampleOffset),
+		HashChars:       w.hashChars,
+		DatePartitioned: w.dates != nil,
+	}
+}
+
+// diff describes how the key format of m, as created, differs from the
+// configured one, or returns "" if it does not.
+func (m createdMarker) diff(configured createdMarker) string {
+	var diffs []string
+	field := func(name string, created, configured any) {
+		if created != configured {
+			diffs = append(diffs, fmt.Sprintf("%s is %v, configured %v", name, created, configured))
+		}
+	}
+	field("separator", fmt.Sprintf("%q", m.Separator), fmt.Sprintf("%q", configured.Separator))
+	field("offset width", m.OffsetWidth, configured.OffsetWidth)
+	field(fmt.Sprintf("name of offset %d", createdSampleOffset), m.SampleName, configured.SampleName)
+	field("hash prefix", m.HashChars, configured.HashChars)
+	field("date partitioning", m.DatePartitioned, configured.DatePartitioned)
+	return strings.Join(diffs, ", ")
+}
+
+// Create initializes a new, empty WAL by writing a <prefix>/_created marker
+// recording its key format, so that an intentionally empty WAL can be told
+// from a prefix pointing nowhere. It fails with ErrWALExists if any object
+// other than the WAL's own "_"-prefixed ones exists under the prefix, or if
+// another Create won the race for the marker. Recover then checks that its
+// options produce the same keys, see ErrFormatMismatch.
+func (w *S3WAL) Create(ctx context.Context) error {
+	if err := w.beginOp(); err != nil {
+		return err
+	}
+	defer w.endOp()
+
+	input := &s3.ListObjectsV2Input{
+		Bucket:              aws.String(w.bucketName),
+		ExpectedBucketOwner: w.bucketOwner(),
+		RequestPayer:        w.requestPayer,
+		Prefix:              aws.String(w.listPrefix()),
+		Delimiter:           w.listDelimiter(),
+	}
+	paginator := s3.NewListObjectsV2Paginator(w.client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx, s3Options(ctx)...)
+		if err != nil {
+			return fmt.Errorf("list objects during create: %w", err)
+		}
+		for _, obj := range page.Contents {
+			// keys that do not parse count too: they may be records written
+			// with other options
+			key := aws.ToString(obj.Key)
+			if !strings.HasPrefix(key, w.listPrefix()+"_") {
+				return fmt.Errorf("%w: found %s", ErrWALExists, key)
+			}
+		}
+	}
+
+	m := w.currentFormat()
+	m.Created = time.Now().UTC()
+	data, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("encode created marker: %w", err)
+	}
+	key := w.createdMarkerKey()
+	_, err = w.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:              aws.String(w.bucketName),
+		ACL:                 w.acl,
+		ExpectedBucketOwner: w.bucketOwner(),
+		RequestPayer:        w.requestPayer,
+		Key:                 aws.String(key),
+		Body:                bytes.NewReader(data),
+		IfNoneMatch:         aws.String("*"),
+	}, s3Options(ctx)...)
+	if err != nil {
+		if isPreconditionFailed(err) {
+			return fmt.Errorf("%w: %s exists", ErrWALExists, key)
+		}
+		return fmt.Errorf("put created marker %s: %w", key, err)
+	}
+
+	w.mu.Lock()
+	w.formatChecked = true
+	w.mu.Unlock()
+	return nil
+}
+
+// checkFormat compares the _created marker, if there is one, with this
+// S3WAL's key format, once per S3WAL. A WAL not made with Create has no
+// marker and is not checked.
+func (w *S3WAL) checkFormat(ctx context.Context) error {
+	w.mu.Lock()
+	checked := w.formatChecked
+	w.mu.Unlock()
+	if checked {
+		return nil
+	}
+
+	key := w.createdMarkerKey()
+	out, err := w.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket:              aws.String(w.bucketName),
+		ExpectedBucketOwner: w.bucketOwner(),
+		RequestPayer:        w.requestPayer,
+		Key:                 aws.String(key),
+	}, s3Options(ctx)...)
+	if err != nil && !isNotFound(err) {
+		return fmt.Errorf("get created marker %s: %w", key, err)
+	}
+	if err == nil {
+		defer out.Body.Close()
+		data, err := io.ReadAll(out.Body)
+		if err != nil {
+			return fmt.Errorf("read created marker %s: %w", key, err)
+		}
+		var m createdMarker
+		if err := json.Unmarshal(data, &m); err != nil {
+			return fmt.Errorf("parse created marker %s: %w", key, err)
+		}
+		if m.Version > createdMarkerVersion {
+			return fmt.Errorf("%w: created marker version %d is newer than %d", ErrFormatMismatch, m.Version, createdMarkerVersion)
+		}
+		if diff := m.diff(w.currentFormat()); diff != "" {
+			return fmt.Errorf("%w: %s", ErrFormatMismatch, diff)
+		}
+	}
+
+	w.mu.Lock()
+	w.formatChecked = true
+	w.mu.Unlock()
+	return nil
+}
+
+// createdMarkerKey is the object Create writes. Like checkpoints it never
+// parses as an offset, so scans skip it.
+func (w *S3WAL) createdMarkerKey() string {
+	return w.listPrefix() + "_created"
+}
@@ -0,0 +1,58 @@
+package s3_log
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// cursorVersion leads every cursor so that the encoding can change without
+// misreading cursors clients persisted earlier.
+const cursorVersion = 1
+
+// Cursor returns an opaque token for a consumer that has read up to and
+// including offset. Clients persist it and pass it to ReadFromCursor to
+// resume; Cursor(0) is the position before the first record.
+func Cursor(offset uint64) string {
+	var buf [9]byte
+	buf[0] = cursorVersion
+	binary.BigEndian.PutUint64(buf[1:], offset)
+	return base64.RawURLEncoding.EncodeToString(buf[:])
+}
+
+// ParseCursor returns the last-read offset encoded by Cursor. The empty
+// string is accepted as the position before the first record.
+func ParseCursor(cursor string) (uint64, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+	buf, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || len(buf) != 9 || buf[0] != cursorVersion {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidCursor, cursor)
+	}
+	return binary.BigEndian.Uint64(buf[1:]), nil
+}
+
+// ReadFromCursor reads up to maxCount stored records after the position of
+// cursor and returns them with the cursor to resume from. At the end of the
+// WAL no records are returned and the cursor is handed back unchanged, so a
+// consumer can poll with it.
+func (w *S3WAL) ReadFromCursor(ctx context.Context, cursor string, maxCount int) ([]Record, string, error) {
+	offset, err := ParseCursor(cursor)
+	if err != nil {
+		return nil, cursor, err
+	}
+	if offset == math.MaxUint64 {
+		return nil, cursor, nil
+	}
+	records, _, err := w.ReadBatch(ctx, offset+1, maxCount, math.MaxInt64)
+	if err != nil {
+		return nil, cursor, err
+	}
+	if len(records) == 0 {
+		return nil, cursor, nil
+	}
+	return records, Cursor(records[len(records)-1].Offset), nil
+}
@@ -0,0 +1,128 @@
+package s3_log
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// datePartitionLayout is the WithDatePartitioning directory of a record.
+const datePartitionLayout = "2006/01/02/"
+
+// datePartitions is the offset→partition index of WithDatePartitioning.
+// Since records are written in offset order, each partition holds a
+// contiguous range of offsets and the index only keeps where each starts.
+type datePartitions struct {
+	mu     sync.Mutex
+	starts []partitionStart // ordered by first offset and by dir
+}
+
+type partitionStart struct {
+	first uint64
+	dir   string // "yyyy/mm/dd/"
+}
+
+// dir returns the partition of offset, "" if the index knows none.
+func (p *datePartitions) dir(offset uint64) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	i := sort.Search(len(p.starts), func(i int) bool { return p.starts[i].first > offset })
+	if i == 0 {
+		return ""
+	}
+	return p.starts[i-1].dir
+}
+
+// startAt is called before records from offset first onwards are written at
+// the tail: they go to the partition of now, unless it is older than the
+// latest one, so that partitions stay in offset order despite clock skew.
+func (p *datePartitions) startAt(first uint64, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	dir := now.UTC().Format(datePartitionLayout)
+	if n := len(p.starts); n > 0 && (dir <= p.starts[n-1].dir || first <= p.starts[n-1].first) {
+		return
+	}
+	p.starts = append(p.starts, partitionStart{first, dir})
+}
+
+// dropFrom forgets the partitions starting at or after offset, once
+// Truncate deleted their records.
+func (p *datePartitions) dropFrom(offset uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	i := sort.Search(len(p.starts), func(i int) bool { return p.starts[i].first >= offset })
+	p.starts = p.starts[:i]
+}
+
+// splitDatePartition cuts the "yyyy/mm/dd/" directory off a key name.
+func splitDatePartition(name string) (dir, rest string, ok bool) {
+	n := len(datePartitionLayout)
+	if len(name) <= n {
+		return "", "", false
+	}
+	if _, err := time.Parse(datePartitionLayout, name[:n]); err != nil {
+		return "", "", false
+	}
+	return name[:n], name[n:], true
+}
+
+// loadDatePartitions rebuilds the partition index by listing the first key
+// of every partition, one request each.
+func (w *S3WAL) loadDatePartitions(ctx context.Context) error {
+	var starts []partitionStart
+	startAfter := ""
+	for {
+		input := &s3.ListObjectsV2Input{
+			Bucket:              aws.String(w.bucketName),
+			ExpectedBucketOwner: w.bucketOwner(),
+			RequestPayer:        w.requestPayer,
+			Prefix:              aws.String(w.listPrefix()),
+			MaxKeys:             aws.Int32(1),
+		}
+		if startAfter != "" {
+			input.StartAfter = aws.String(startAfter)
+		}
+		out, err := w.client.ListObjectsV2(ctx, input, s3Options(ctx)...)
+		if err != nil {
+			return fmt.Errorf("list date partitions: %w", err)
+		}
+		if len(out.Contents) == 0 {
+			break
+		}
+		key := aws.ToString(out.Contents[0].Key)
+		dir, _, ok := splitDatePartition(key[len(w.listPrefix()):])
+		if !ok {
+			// the WAL's own "_" objects sort after every partition
+			break
+		}
+		if first, _, err := w.getRangeFromKey(key); err == nil {
+			starts = append(starts, partitionStart{first, dir})
+		}
+		// "~" sorts after every key name in the partition
+		startAfter = w.listPrefix() + dir + "~"
+	}
+	w.dates.mu.Lock()
+	w.dates.starts = starts
+	w.dates.mu.Unlock()
+	return nil
+}
+
+// reloadPartition is called when the key of offset was not found: it
+// rebuilds the WithDatePartitioning index and reports whether offset moved
+// to another partition, so that the caller retries with the new key.
+func (w *S3WAL) reloadPartition(ctx context.Context, offset uint64) bool {
+	if w.dates == nil {
+		return false
+	}
+	before := w.dates.dir(offset)
+	if err := w.loadDatePartitions(ctx); err != nil {
+		return false
+	}
+	return w.dates.dir(offset) != before
+}
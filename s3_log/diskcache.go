@@ -0,0 +1,161 @@
+package s3_log
+
+import (
+	"container/list"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// diskCache keeps record bodies fetched from S3 in files named after their
+// offset, evicting the least recently used ones beyond maxBytes. It is best
+// effort: any file system error just means a miss.
+type diskCache struct {
+	dir      string
+	maxBytes int64
+	initErr  error // reported by Validate; the cache stays empty
+
+	mu      sync.Mutex
+	size    int64
+	lru     *list.List // of *diskEntry, most recently used first
+	entries map[uint64]*list.Element
+}
+
+type diskEntry struct {
+	offset uint64
+	size   int64
+}
+
+// newDiskCache creates dir if needed and indexes the bodies left in it by
+// earlier runs, oldest first for eviction.
+func newDiskCache(dir string, maxBytes int64) *diskCache {
+	c := &diskCache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		entries:  make(map[uint64]*list.Element),
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		c.initErr = fmt.Errorf("create disk cache dir: %w", err)
+		return c
+	}
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		c.initErr = fmt.Errorf("read disk cache dir: %w", err)
+		return c
+	}
+
+	type found struct {
+		entry   diskEntry
+		modTime int64
+	}
+	var files []found
+	for _, de := range dirEntries {
+		offset, err := parseOffset(de.Name())
+		if err != nil || !de.Type().IsRegular() {
+			continue
+		}
+		info, err := de.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, found{diskEntry{offset, info.Size()}, info.ModTime().UnixNano()})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime > files[j].modTime })
+	for _, f := range files {
+		entry := f.entry
+		c.entries[entry.offset] = c.lru.PushBack(&entry)
+		c.size += entry.size
+	}
+	c.evictLocked()
+	return c
+}
+
+func (c *diskCache) path(offset uint64) string {
+	return filepath.Join(c.dir, fmt.Sprintf("%020d", offset))
+}
+
+// get returns the cached body of offset.
+func (c *diskCache) get(offset uint64) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[offset]
+	if !ok {
+		return nil, false
+	}
+	body, err := os.ReadFile(c.path(offset))
+	if err != nil {
+		c.removeLocked(el)
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	return body, true
+}
+
+// put stores the body of offset, replacing an older one.
+func (c *diskCache) put(offset uint64, body []byte) {
+	if c.initErr != nil || int64(len(body)) > c.maxBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[offset]; ok {
+		c.removeLocked(el)
+	}
+
+	// write to a temp file first so a crash never leaves a partial body
+	tmp, err := os.CreateTemp(c.dir, "tmp-*")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(body)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), c.path(offset))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+
+	c.entries[offset] = c.lru.PushFront(&diskEntry{offset, int64(len(body))})
+	c.size += int64(len(body))
+	c.evictLocked()
+}
+
+// remove drops offset from the cache.
+func (c *diskCache) remove(offset uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[offset]; ok {
+		c.removeLocked(el)
+	}
+}
+
+// removeAfter drops every offset > after, e.g. once they are truncated.
+func (c *diskCache) removeAfter(after uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for offset, el := range c.entries {
+		if offset > after {
+			c.removeLocked(el)
+		}
+	}
+}
+
+func (c *diskCache) removeLocked(el *list.Element) {
+	entry := c.lru.Remove(el).(*diskEntry)
+	delete(c.entries, entry.offset)
+	c.size -= entry.size
+	os.Remove(c.path(entry.offset))
+}
+
+func (c *diskCache) evictLocked() {
+	for c.size > c.maxBytes && c.lru.Len() > 0 {
+		c.removeLocked(c.lru.Back())
+	}
+}
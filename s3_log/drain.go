@@ -0,0 +1,42 @@
+package s3_log
+
+import "context"
+
+// Drain prepares the WAL for shutdown: operations started from now on fail
+// with ErrDraining, and Drain waits until those already running (appends,
+// reads, truncates, recovers) have finished or ctx is done. Follow and
+// FollowViaSQS stop with ErrDraining on their next read. Call Close afterwards
+// to flush buffered segment records.
+func (w *S3WAL) Drain(ctx context.Context) error {
+	w.drainMu.Lock()
+	w.draining = true
+	w.drainMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		w.inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// beginOp registers an operation with Drain. Every successful call must be
+// matched by endOp.
+func (w *S3WAL) beginOp() error {
+	w.drainMu.RLock()
+	defer w.drainMu.RUnlock()
+	if w.draining {
+		return ErrDraining
+	}
+	w.inflight.Add(1)
+	return nil
+}
+
+func (w *S3WAL) endOp() {
+	w.inflight.Done()
+}
@@ -0,0 +1,21 @@
+package s3_log
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"fmt"
+)
+
+// NewAESGCM returns an AES-GCM AEAD for WithClientEncryption. key must be
+// 16, 24 or 32 bytes long, selecting AES-128, AES-192 or AES-256.
+func NewAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("create AES cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("create GCM: %w", err)
+	}
+	return aead, nil
+}
@@ -0,0 +1,124 @@
+package s3_log
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// hostRecorder is an HTTP client that records the host of every request and
+// fails it, standing in for the network.
+type hostRecorder struct {
+	mu    sync.Mutex
+	hosts []string
+}
+
+func (h *hostRecorder) Do(r *http.Request) (*http.Response, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.hosts = append(h.hosts, r.URL.Host)
+	return nil, errors.New("no network in tests")
+}
+
+// WithTransferAcceleration and WithDualStack reach the client
+// NewS3WALFromConfig builds and select the matching endpoints.
+func TestEndpointOptions(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opt  Option
+		host string
+	}{
+		{"default", nil, "bucket.s3.us-west-2.amazonaws.com"},
+		{"acceleration", WithTransferAcceleration(), "bucket.s3-accelerate.amazonaws.com"},
+		{"dualstack", WithDualStack(), "bucket.s3.dualstack.us-west-2.amazonaws.com"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := &hostRecorder{}
+			cfg := aws.Config{
+				Region:           "us-west-2",
+				Credentials:      aws.AnonymousCredentials{},
+				HTTPClient:       rec,
+				RetryMaxAttempts: 1,
+			}
+			var opts []Option
+			if tc.opt != nil {
+				opts = append(opts, tc.opt)
+			}
+			w, err := NewS3WALFromConfig(cfg, "bucket", "wal", opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer w.Close()
+			if _, err := w.Read(bg, 1); err == nil {
+				t.Fatal("Read succeeded without a network")
+			}
+			if len(rec.hosts) == 0 || rec.hosts[0] != tc.host {
+				t.Fatalf("requests went to %q, want %s", rec.hosts, tc.host)
+			}
+		})
+	}
+}
+
+// NewS3WALFromConfig with WithForcePathStyle works against an endpoint that
+// only understands path-style requests, such as MinIO or another
+// S3-compatible store without wildcard DNS.
+func TestForcePathStyleEndpoint(t *testing.T) {
+	f, _ := newFake(t)
+	u, err := url.Parse(f.url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a named host, for which the SDK would otherwise go virtual-hosted,
+	// dialled without DNS so that such requests reach the fake and fail there
+	dialer := &net.Dialer{}
+	cfg := aws.Config{
+		Region:           "us-east-1",
+		Credentials:      aws.AnonymousCredentials{},
+		BaseEndpoint:     aws.String(strings.Replace(f.url, u.Hostname(), "s3.example.test", 1)),
+		RetryMaxAttempts: 1,
+		HTTPClient: &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, u.Host)
+			},
+		}},
+	}
+
+	w, err := NewS3WALFromConfig(cfg, "bucket", "wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Append(bg, []byte("data")); err == nil {
+		t.Fatal("virtual-hosted Append succeeded against a path-style only endpoint")
+	}
+	w.Close()
+
+	w, err = NewS3WALFromConfig(cfg, "bucket", "wal", WithForcePathStyle())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	for i := 0; i < 2; i++ {
+		if _, err := w.Append(bg, []byte("data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if length, err := w.Recover(bg); err != nil || length != 2 {
+		t.Fatalf("Recover = %d, %v, want 2", length, err)
+	}
+	if rec, err := w.Read(bg, 2); err != nil || string(rec.Data) != "data" {
+		t.Fatalf("Read(2) = %q, %v", rec.Data, err)
+	}
+	if err := w.Truncate(bg, 1); err != nil {
+		t.Fatal(err)
+	}
+	if keys := f.keys(); len(keys) != 1 || keys[0] != "wal/00000000000000000001" {
+		t.Fatalf("keys = %q, want record 1 only", keys)
+	}
+}
@@ -0,0 +1,154 @@
+package s3_log
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// ErrOffsetExists is returned by AppendAtIfAbsent when a record is already
+// stored at the requested offset.
+var ErrOffsetExists = errors.New("s3_log: offset already exists")
+
+// ErrOffsetNotFound is returned by Overwrite when there is no record at the
+// requested offset.
+var ErrOffsetNotFound = errors.New("s3_log: offset not found")
+
+// ErrKeyNotFound is returned by ReadByKey when no record was appended under
+// the key.
+var ErrKeyNotFound = errors.New("s3_log: key not found")
+
+// ErrETagNotFound is returned by FindByETag when no object has the ETag.
+var ErrETagNotFound = errors.New("s3_log: etag not found")
+
+// ErrUnknownKey is returned by Recover and Truncate under WithStrictKeys for
+// a key under the prefix that is not a WAL object.
+var ErrUnknownKey = errors.New("s3_log: unknown key under WAL prefix")
+
+// ErrInvalidPrefix is returned by Validate and NewS3WALE for a prefix that
+// cannot hold a WAL.
+var ErrInvalidPrefix = errors.New("s3_log: invalid prefix")
+
+// ErrRecordChanged is returned by Read with IfMatch when the record's object
+// no longer has the expected ETag.
+var ErrRecordChanged = errors.New("s3_log: record changed")
+
+// ErrChainBroken is returned by VerifyChain when a record does not carry the
+// hash of the record before it.
+var ErrChainBroken = errors.New("s3_log: hash chain broken")
+
+// ErrRecordTooShort is returned by Read and the other readers for a record
+// body too short for its header and checksum, e.g. a truncated download.
+var ErrRecordTooShort = errors.New("s3_log: record too short")
+
+// ErrChecksumMismatch is returned by Read and the other readers for a record
+// whose body does not match its checksum, i.e. corrupted data.
+var ErrChecksumMismatch = errors.New("s3_log: checksum mismatch")
+
+// ErrS3ChecksumMismatch is wrapped by Read errors under WithS3NativeChecksum
+// when an object does not match the checksum S3 stored for it.
+var ErrS3ChecksumMismatch = errors.New("s3_log: S3 checksum mismatch")
+
+// ErrOffsetMismatch is returned by Read and the other readers for a record
+// whose body carries another offset than its key, e.g. a body copied to
+// another key; see RestampOffsets.
+var ErrOffsetMismatch = errors.New("s3_log: offset mismatch")
+
+// ErrCASFailed is wrapped by the *CASError CompareAndAppend returns when the
+// head of the WAL is not the expected one.
+var ErrCASFailed = errors.New("s3_log: compare and append failed")
+
+// ErrInvalidCursor is returned by ParseCursor and ReadFromCursor for a
+// string that Cursor did not produce.
+var ErrInvalidCursor = errors.New("s3_log: invalid cursor")
+
+// ErrWALExists is returned by Create when the prefix already holds objects
+// or was created before.
+var ErrWALExists = errors.New("s3_log: WAL already exists")
+
+// ErrFormatMismatch is returned by Recover when the key format recorded by
+// Create differs from the one the S3WAL's options produce.
+var ErrFormatMismatch = errors.New("s3_log: WAL format mismatch")
+
+// ErrUnknownEventType is returned by AppendEvent and ReadEvent for a tag
+// that was not registered with RegisterType.
+var ErrUnknownEventType = errors.New("s3_log: unknown event type")
+
+// ErrDraining is returned by operations started after Drain.
+var ErrDraining = errors.New("s3_log: WAL is draining")
+
+// ErrRetentionViolation is returned by Truncate when it would leave fewer
+// records than configured with WithMinRetainedRecords.
+var ErrRetentionViolation = errors.New("s3_log: truncate would violate minimum retention")
+
+// ErrFrameTooLarge is returned by ImportFrom for a frame longer than
+// maxFrameSize, which is most likely not a frame at all.
+var ErrFrameTooLarge = errors.New("s3_log: frame too large")
+
+// isPreconditionFailed reports whether err is S3 rejecting a conditional request.
+func isPreconditionFailed(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "PreconditionFailed"
+}
+
+// CASError reports the actual head of the WAL to a CompareAndAppend that
+// expected another one: the hash of the record at Offset, as ChainHead
+// returns it. It wraps ErrCASFailed.
+type CASError struct {
+	Head   []byte
+	Offset uint64
+}
+
+func (e *CASError) Error() string {
+	return fmt.Sprintf("%v: head is offset %d (%x)", ErrCASFailed, e.Offset, e.Head)
+}
+
+func (e *CASError) Unwrap() error {
+	return ErrCASFailed
+}
+
+// BatchAppendError reports the records a BestEffort BatchAppend failed to
+// store, by the offset they were assigned.
+type BatchAppendError struct {
+	Failed map[uint64]error
+}
+
+func (e *BatchAppendError) Error() string {
+	offsets := make([]uint64, 0, len(e.Failed))
+	for offset := range e.Failed {
+		offsets = append(offsets, offset)
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+	parts := make([]string, len(offsets))
+	for i, offset := range offsets {
+		parts[i] = e.Failed[offset].Error()
+	}
+	return fmt.Sprintf("batch append errors: %s", strings.Join(parts, "; "))
+}
+
+// DeleteFailure is an object S3 refused to delete.
+type DeleteFailure struct {
+	Object  types.ObjectIdentifier
+	Code    string
+	Message string
+}
+
+// DeleteError reports the objects a Truncate could not delete, so that just
+// those can be retried. Every other object was deleted.
+type DeleteError struct {
+	Failed []DeleteFailure
+}
+
+func (e *DeleteError) Error() string {
+	// concatenate errors for better debugging
+	parts := make([]string, len(e.Failed))
+	for i, f := range e.Failed {
+		parts[i] = fmt.Sprintf("%s: %s", aws.ToString(f.Object.Key), f.Message)
+	}
+	return fmt.Sprintf("delete objects errors: %s", strings.Join(parts, "; "))
+}
@@ -0,0 +1,47 @@
+package s3_log
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// FindByETag returns the first record, in offset order, whose object has the
+// given ETag, with or without the surrounding quotes, e.g. to check whether
+// some content was already appended. For a segment the ETag covers the whole
+// object and its first record is returned. It lists the WAL and is O(N) in
+// the number of objects: for frequent lookups keep an index from content hash
+// to offset instead, e.g. with AppendKeyed. It returns ErrETagNotFound if no
+// object matches. Buffered records have no ETag and are not searched.
+func (w *S3WAL) FindByETag(ctx context.Context, etag string) (Record, error) {
+	if err := w.beginOp(); err != nil {
+		return Record{}, err
+	}
+	defer w.endOp()
+
+	want := strings.Trim(etag, `"`)
+	var rec Record
+	found := false
+	err := w.walkObjects(ctx, func(obj types.Object, first, last uint64) error {
+		if strings.Trim(aws.ToString(obj.ETag), `"`) != want {
+			return nil
+		}
+		var err error
+		if rec, err = w.readStored(ctx, first, aws.ToString(obj.ETag)); err != nil {
+			return err
+		}
+		found = true
+		return errStopWalk
+	})
+	if err != nil && !errors.Is(err, errStopWalk) {
+		return Record{}, err
+	}
+	if !found {
+		return Record{}, fmt.Errorf("etag %s: %w", etag, ErrETagNotFound)
+	}
+	return rec, nil
+}
@@ -0,0 +1,86 @@
+package s3_log
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// Events are records whose data is an envelope of a type tag and the JSON
+// encoding of a value: [1-byte tag length][tag][JSON]. The tag selects the Go
+// type registered with RegisterType to decode the value into; the records are
+// ordinary ones to every other method.
+
+// RegisterType makes tag name the type of proto for AppendEvent and
+// ReadEvent. ReadEvent returns values of exactly that type, so register a
+// pointer type to get pointers back. Registering a tag again replaces it.
+func (w *S3WAL) RegisterType(tag string, proto any) {
+	if tag == "" || len(tag) > 255 {
+		panic(fmt.Sprintf("s3_log: invalid event tag %q: want 1 to 255 bytes", tag))
+	}
+	if proto == nil {
+		panic("s3_log: invalid event type: nil")
+	}
+	w.eventsMu.Lock()
+	defer w.eventsMu.Unlock()
+	if w.eventTypes == nil {
+		w.eventTypes = make(map[string]reflect.Type)
+	}
+	w.eventTypes[tag] = reflect.TypeOf(proto)
+}
+
+// AppendEvent appends v, which must be of the type registered for tag, as a
+// JSON encoded event and returns its offset.
+func (w *S3WAL) AppendEvent(ctx context.Context, tag string, v any) (uint64, error) {
+	t, err := w.eventType(tag)
+	if err != nil {
+		return 0, err
+	}
+	if reflect.TypeOf(v) != t {
+		return 0, fmt.Errorf("s3_log: event %q is %T, registered as %v", tag, v, t)
+	}
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return 0, fmt.Errorf("encode event %q: %w", tag, err)
+	}
+	data := make([]byte, 0, 1+len(tag)+len(payload))
+	data = append(data, byte(len(tag)))
+	data = append(data, tag...)
+	data = append(data, payload...)
+	return w.Append(ctx, data)
+}
+
+// ReadEvent reads the event at offset and returns its value, of the type
+// registered for its tag. A record that is not an event fails with an error,
+// one of an unregistered tag with an error wrapping ErrUnknownEventType.
+func (w *S3WAL) ReadEvent(ctx context.Context, offset uint64) (any, error) {
+	rec, err := w.Read(ctx, offset)
+	if err != nil {
+		return nil, err
+	}
+	if len(rec.Data) == 0 || len(rec.Data) < 1+int(rec.Data[0]) || rec.Data[0] == 0 {
+		return nil, fmt.Errorf("s3_log: offset %d is not an event", offset)
+	}
+	n := int(rec.Data[0])
+	tag, payload := string(rec.Data[1:1+n]), rec.Data[1+n:]
+	t, err := w.eventType(tag)
+	if err != nil {
+		return nil, fmt.Errorf("offset %d: %w", offset, err)
+	}
+	v := reflect.New(t)
+	if err := json.Unmarshal(payload, v.Interface()); err != nil {
+		return nil, fmt.Errorf("decode event %q at offset %d: %w", tag, offset, err)
+	}
+	return v.Elem().Interface(), nil
+}
+
+func (w *S3WAL) eventType(tag string) (reflect.Type, error) {
+	w.eventsMu.RLock()
+	defer w.eventsMu.RUnlock()
+	t, ok := w.eventTypes[tag]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownEventType, tag)
+	}
+	return t, nil
+}
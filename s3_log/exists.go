@@ -0,0 +1,59 @@
+package s3_log
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Exists reports whether a record is stored at offset, with a HeadObject
+// instead of downloading and validating it like Read, e.g. to find the gaps
+// left by deletes. A missing key is (false, nil); any other failure is
+// returned. Records buffered by WithSegmentation count as stored, and when
+// the key is missing one more listing request looks for a segment or group
+// object holding offset, so Exists agrees with Read on where records are.
+func (w *S3WAL) Exists(ctx context.Context, offset uint64) (bool, error) {
+	if err := w.beginOp(); err != nil {
+		return false, err
+	}
+	defer w.endOp()
+
+	if w.segment != nil {
+		w.mu.Lock()
+		_, ok := w.pendingRecord(offset)
+		w.mu.Unlock()
+		if ok {
+			return true, nil
+		}
+	}
+
+	head := func(key string) error {
+		return w.withFailover(func(client S3API, bucket string) error {
+			_, err := client.HeadObject(ctx, &s3.HeadObjectInput{
+				Bucket:              aws.String(bucket),
+				ExpectedBucketOwner: w.bucketOwner(),
+				RequestPayer:        w.requestPayer,
+				Key:                 aws.String(key),
+			}, s3Options(ctx)...)
+			return err
+		})
+	}
+	err := head(w.getObjectKey(offset))
+	if err != nil && isNotFound(err) && w.reloadPartition(ctx, offset) {
+		err = head(w.getObjectKey(offset))
+	}
+	if err == nil {
+		return true, nil
+	}
+	if !isNotFound(err) {
+		return false, fmt.Errorf("head object (offset=%d): %w", offset, err)
+	}
+
+	_, _, _, found, err := w.locateSegment(ctx, offset)
+	if err != nil {
+		return false, err
+	}
+	return found, nil
+}
@@ -0,0 +1,42 @@
+package s3_log
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// ExpiryTagKey is the object tag AppendWithExpiry sets to the number of days
+// a record should be kept.
+const ExpiryTagKey = "s3wal-expire-days"
+
+// AppendWithExpiry appends data like Append and tags its object with
+// ExpiryTagKey set to ttl in whole days, rounded up. S3 deletes nothing by
+// itself: the bucket needs a lifecycle rule per TTL in use that filters on
+// the tag and expires objects after that many days, e.g. for a 7 day TTL
+//
+//	{"Filter": {"Tag": {"Key": "s3wal-expire-days", "Value": "7"}},
+//	 "Expiration": {"Days": 7}, "Status": "Enabled"}
+//
+// Other retention automation can act on the tag too. The Expires header is
+// set to now plus ttl as well, but it only affects caching. In segment mode
+// buffered records are flushed first and the record is stored as its own
+// object, as tags apply to whole objects. Expired records simply disappear:
+// older ones leave gaps, and if the newest expire, Recover reports a lower
+// length and their offsets are reused.
+func (w *S3WAL) AppendWithExpiry(ctx context.Context, data []byte, ttl time.Duration) (uint64, error) {
+	if ttl <= 0 {
+		return 0, fmt.Errorf("s3_log: invalid expiry %v", ttl)
+	}
+	offset, _, err := w.append(ctx, data, ttl, true)
+	return offset, err
+}
+
+// expiryTagging returns the tag set of a record expiring after ttl, in the
+// URL query form PutObject takes.
+func expiryTagging(ttl time.Duration) string {
+	days := (ttl + 24*time.Hour - 1) / (24 * time.Hour)
+	return url.Values{ExpiryTagKey: {strconv.FormatInt(int64(days), 10)}}.Encode()
+}
@@ -0,0 +1,296 @@
+package s3_log
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+var bg = context.Background()
+
+// fakeObject is an object stored by fakeS3.
+type fakeObject struct {
+	data     []byte
+	header   http.Header // of the PutObject that stored it
+	modified time.Time
+}
+
+func (o fakeObject) etag() string {
+	sum := md5.Sum(o.data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// fakeS3 is an in-memory S3 endpoint for one bucket, named "bucket", serving
+// the requests S3WAL sends: conditional PutObject, ranged GetObject,
+// HeadObject, ListObjectsV2, DeleteObject and DeleteObjects. Like many
+// S3-compatible stores it only understands path-style requests.
+type fakeS3 struct {
+	url string // of the endpoint
+
+	mu      sync.Mutex
+	objects map[string]fakeObject
+	reqs    []*http.Request // every request, in order
+	down    bool            // answer every request with a 503
+	failPut int             // fail the next failPut PutObjects with a 500
+	denyPut map[string]bool // keys PutObject refuses with AccessDenied
+	failDel map[string]bool // keys DeleteObjects refuses with AccessDenied
+}
+
+// newFake starts a fakeS3 and returns it with a client for it.
+func newFake(t testing.TB) (*fakeS3, *s3.Client) {
+	f := &fakeS3{objects: map[string]fakeObject{}}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	f.url = srv.URL
+	return f, fakeClient(srv.URL)
+}
+
+// fakeClient returns a client sending path-style requests to url. Failed
+// requests are not retried.
+func fakeClient(url string) *s3.Client {
+	return s3.New(s3.Options{
+		Region:           "us-east-1",
+		BaseEndpoint:     aws.String(url),
+		UsePathStyle:     true,
+		Credentials:      aws.AnonymousCredentials{},
+		RetryMaxAttempts: 1,
+	})
+}
+
+// keys returns the stored keys in order.
+func (f *fakeS3) keys() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	keys := make([]string, 0, len(f.objects))
+	for k := range f.objects {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// setModified changes the LastModified time of key.
+func (f *fakeS3) setModified(key string, t time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	o := f.objects[key]
+	o.modified = t
+	f.objects[key] = o
+}
+
+// requests returns the requests served so far.
+func (f *fakeS3) requests() []*http.Request {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]*http.Request(nil), f.reqs...)
+}
+
+func (f *fakeS3) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.reqs = append(f.reqs, r)
+
+	// /<bucket>/<key>
+	_, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	q := r.URL.Query()
+	fail := func(status int, code string) {
+		rw.WriteHeader(status)
+		if r.Method != http.MethodHead {
+			fmt.Fprintf(rw, `<Error><Code>%s</Code><Message>%s</Message></Error>`, code, code)
+		}
+	}
+
+	switch {
+	case strings.HasPrefix(r.Host, "bucket."):
+		// virtual-hosted style, which needs wildcard DNS
+		fail(http.StatusBadRequest, "InvalidRequest")
+
+	case f.down:
+		fail(http.StatusServiceUnavailable, "ServiceUnavailable")
+
+	case r.Method == http.MethodPut:
+		if f.failPut > 0 {
+			f.failPut--
+			fail(http.StatusInternalServerError, "InternalError")
+			return
+		}
+		if f.denyPut[key] {
+			fail(http.StatusForbidden, "AccessDenied")
+			return
+		}
+		old, exists := f.objects[key]
+		if r.Header.Get("If-None-Match") == "*" && exists {
+			fail(http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+		if im := r.Header.Get("If-Match"); im != "" && (!exists || im != old.etag()) {
+			fail(http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		if r.Header.Get("Content-Encoding") == "aws-chunked" {
+			data = decodeChunked(data)
+		}
+		o := fakeObject{data: data, header: r.Header.Clone(), modified: time.Now()}
+		f.objects[key] = o
+		rw.Header().Set("ETag", o.etag())
+
+	case key == "" && q.Get("list-type") == "2":
+		f.list(rw, q)
+
+	case key == "" && r.Method == http.MethodHead:
+		// HeadBucket
+
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		o, ok := f.objects[key]
+		if !ok {
+			fail(http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		if im := r.Header.Get("If-Match"); im != "" && im != o.etag() {
+			fail(http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+		data := o.data
+		if rg, ok := strings.CutPrefix(r.Header.Get("Range"), "bytes="); ok {
+			startStr, endStr, _ := strings.Cut(rg, "-")
+			start, _ := strconv.Atoi(startStr)
+			end, _ := strconv.Atoi(endStr)
+			end = min(end, len(data)-1)
+			if start > end {
+				data = nil
+			} else {
+				data = data[start : end+1]
+			}
+			rw.Header().Set("Content-Range", fmt.Sprintf("bytes %s/%d", rg, len(o.data)))
+		}
+		for k, v := range o.header {
+			if strings.HasPrefix(strings.ToLower(k), "x-amz-meta-") {
+				rw.Header()[k] = v
+			}
+		}
+		rw.Header().Set("ETag", o.etag())
+		rw.Header().Set("Last-Modified", o.modified.UTC().Format(http.TimeFormat))
+		rw.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		if r.Method == http.MethodGet {
+			rw.Write(data)
+		}
+
+	case r.Method == http.MethodPost && q.Has("delete"):
+		body, _ := io.ReadAll(r.Body)
+		var req struct {
+			Objects []struct{ Key string } `xml:"Object"`
+		}
+		xml.Unmarshal(body, &req)
+		var out strings.Builder
+		out.WriteString(`<DeleteResult>`)
+		for _, o := range req.Objects {
+			if f.failDel[o.Key] {
+				fmt.Fprintf(&out, `<Error><Key>%s</Key><Code>AccessDenied</Code><Message>denied</Message></Error>`, o.Key)
+				continue
+			}
+			delete(f.objects, o.Key)
+			fmt.Fprintf(&out, `<Deleted><Key>%s</Key></Deleted>`, o.Key)
+		}
+		out.WriteString(`</DeleteResult>`)
+		io.WriteString(rw, out.String())
+
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		rw.WriteHeader(http.StatusNoContent)
+
+	default:
+		fail(http.StatusBadRequest, "BadRequest")
+	}
+}
+
+// list serves ListObjectsV2, with prefix, delimiter, start-after, max-keys and
+// continuation tokens.
+func (f *fakeS3) list(rw http.ResponseWriter, q map[string][]string) {
+	get := func(k string) string {
+		if v := q[k]; len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+	prefix, delim := get("prefix"), get("delimiter")
+	after := get("start-after")
+	if token := get("continuation-token"); token != "" {
+		after = token
+	}
+	maxKeys := 1000
+	if m := get("max-keys"); m != "" {
+		maxKeys, _ = strconv.Atoi(m)
+	}
+
+	var keys []string
+	for k := range f.objects {
+		if strings.HasPrefix(k, prefix) && k > after {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var out strings.Builder
+	out.WriteString(`<ListBucketResult>`)
+	seen := map[string]bool{}
+	n, last, truncated := 0, "", false
+	for _, k := range keys {
+		if n >= maxKeys {
+			truncated = true
+			break
+		}
+		last = k
+		if i := strings.Index(k[len(prefix):], delim); delim != "" && i >= 0 {
+			cp := k[:len(prefix)+i+len(delim)]
+			if !seen[cp] {
+				seen[cp] = true
+				fmt.Fprintf(&out, `<CommonPrefixes><Prefix>%s</Prefix></CommonPrefixes>`, cp)
+				n++
+			}
+			continue
+		}
+		o := f.objects[k]
+		fmt.Fprintf(&out, `<Contents><Key>%s</Key><Size>%d</Size><ETag>%s</ETag><LastModified>%s</LastModified></Contents>`,
+			k, len(o.data), o.etag(), o.modified.UTC().Format("2006-01-02T15:04:05.000Z"))
+		n++
+	}
+	fmt.Fprintf(&out, `<KeyCount>%d</KeyCount><IsTruncated>%t</IsTruncated>`, n, truncated)
+	if truncated {
+		fmt.Fprintf(&out, `<NextContinuationToken>%s</NextContinuationToken>`, last)
+	}
+	out.WriteString(`</ListBucketResult>`)
+	io.WriteString(rw, out.String())
+}
+
+// decodeChunked strips the aws-chunked framing of a streamed upload.
+func decodeChunked(b []byte) []byte {
+	var out []byte
+	for {
+		line, rest, ok := strings.Cut(string(b), "\r\n")
+		if !ok {
+			return out
+		}
+		size, _, _ := strings.Cut(line, ";")
+		n, err := strconv.ParseInt(size, 16, 64)
+		if err != nil || n == 0 || int64(len(rest)) < n {
+			return out
+		}
+		out = append(out, rest[:n]...)
+		b = []byte(strings.TrimPrefix(rest[n:], "\r\n"))
+	}
+}
@@ -0,0 +1,187 @@
+package s3_log
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	defaultFollowInterval    = time.Second
+	defaultFollowMaxInterval = 30 * time.Second
+)
+
+// followGapGrace is how long Follow waits for a missing offset that later
+// records exist after before it treats it as a permanent gap.
+var followGapGrace = time.Minute
+
+// Follow emits every record from offset from (inclusive) onwards and then
+// keeps polling S3 for new ones, like tail -f. Records are delivered strictly
+// in offset order, each exactly once. Polling starts at the interval set by
+// WithFollowInterval and doubles while no new record shows up.
+//
+// An offset below MinValidOffset, trimmed by retention, is skipped as soon as
+// a later record exists. Any other missing offset with later records after it
+// may be an upload still in flight, e.g. of a concurrent BatchAppend, and is
+// only skipped as a gap once it has been missing for followGapGrace.
+//
+// Both channels are closed when ctx is cancelled or after the first error,
+// which is sent on the error channel.
+func (w *S3WAL) Follow(ctx context.Context, from uint64) (<-chan Record, <-chan error) {
+	records := make(chan Record)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(errs)
+		defer close(records)
+
+		next := from
+		if next == 0 {
+			next = 1
+		}
+		interval := w.followInterval
+		var gapSince time.Time // when next was first missing with later records
+		for {
+			rec, err := w.Read(ctx, next)
+			if err == nil {
+				select {
+				case records <- rec:
+				case <-ctx.Done():
+					return
+				}
+				next++
+				interval = w.followInterval
+				gapSince = time.Time{}
+				continue
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			if !isNotFound(err) {
+				errs <- err
+				return
+			}
+
+			// nothing at next yet; skip ahead if it is a gap before later records
+			existing, found, err := w.firstOffsetFrom(ctx, next)
+			if err != nil {
+				if ctx.Err() == nil {
+					errs <- err
+				}
+				return
+			}
+			if found && existing > next {
+				skipTo, skip, err := w.skipGap(ctx, next, existing, &gapSince)
+				if err != nil {
+					if ctx.Err() == nil {
+						errs <- err
+					}
+					return
+				}
+				if skip {
+					next = skipTo
+					continue
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(interval):
+			}
+			interval *= 2
+			if interval > w.followMaxInterval {
+				interval = w.followMaxInterval
+			}
+		}
+	}()
+
+	return records, errs
+}
+
+// skipGap decides whether Follow moves on from the missing offset next to
+// existing, the lowest stored offset after it, and returns where to continue.
+// Offsets below MinValidOffset are skipped at once, others once they have
+// been missing for followGapGrace since *since, which it sets when zero and
+// resets when skipping.
+func (w *S3WAL) skipGap(ctx context.Context, next, existing uint64, since *time.Time) (uint64, bool, error) {
+	if !since.IsZero() && time.Since(*since) >= followGapGrace {
+		*since = time.Time{}
+		return existing, true, nil
+	}
+	minValid, err := w.MinValidOffset(ctx)
+	if err != nil {
+		return 0, false, err
+	}
+	if next < minValid {
+		*since = time.Time{}
+		return max(existing, minValid), true, nil
+	}
+	if since.IsZero() {
+		*since = time.Now()
+	}
+	return 0, false, nil
+}
+
+// firstOffsetFrom returns the lowest stored offset >= offset.
+func (w *S3WAL) firstOffsetFrom(ctx context.Context, offset uint64) (uint64, bool, error) {
+	if w.hashChars > 0 {
+		// keys are not in offset order, look at all of them
+		var lowest uint64
+		var found bool
+		err := w.eachObject(ctx, func(_ types.Object, first, last uint64) error {
+			if last >= offset {
+				first = max(first, offset)
+				if !found || first < lowest {
+					lowest, found = first, true
+				}
+			}
+			return nil
+		})
+		return lowest, found, err
+	}
+	input := &s3.ListObjectsV2Input{
+		Bucket:              aws.String(w.bucketName),
+		ExpectedBucketOwner: w.bucketOwner(),
+		RequestPayer:        w.requestPayer,
+		Prefix:              aws.String(w.listPrefix()),
+		Delimiter:           w.listDelimiter(),
+		MaxKeys:             aws.Int32(2),
+	}
+	// start right before offset so a record written since the failed read
+	// is seen here rather than mistaken for a gap
+	if offset > 1 {
+		input.StartAfter = aws.String(w.getObjectKey(offset - 1))
+	}
+	out, err := w.client.ListObjectsV2(ctx, input, s3Options(ctx)...)
+	if err != nil {
+		return 0, false, fmt.Errorf("list objects after offset %d: %w", offset, err)
+	}
+	for _, obj := range out.Contents {
+		if obj.Key == nil {
+			continue
+		}
+		first, last, err := w.getRangeFromKey(*obj.Key)
+		if err != nil || last < offset {
+			// a segment ending right before offset
+			continue
+		}
+		if first < offset {
+			first = offset
+		}
+		return first, true, nil
+	}
+	return 0, false, nil
+}
+
+// isNotFound reports whether err means the requested object does not exist.
+func isNotFound(err error) bool {
+	var nsk *types.NoSuchKey
+	var nf *types.NotFound
+	return errors.As(err, &nsk) || errors.As(err, &nf)
+}
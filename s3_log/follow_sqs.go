@@ -0,0 +1,179 @@
+package s3_log
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+// s3Event is the subset of an S3 event notification FollowViaSQS needs.
+type s3Event struct {
+	Records []struct {
+		EventName string `json:"eventName"`
+		S3        struct {
+			Bucket struct {
+				Name string `json:"name"`
+			} `json:"bucket"`
+			Object struct {
+				Key string `json:"key"`
+			} `json:"object"`
+		} `json:"s3"`
+	} `json:"Records"`
+	// Message carries the S3 event when it was fanned out through SNS.
+	Message string `json:"Message"`
+}
+
+// FollowViaSQS works like Follow but learns about new records from S3
+// ObjectCreated notifications delivered to the SQS queue at queueURL instead
+// of polling. The bucket must publish events for the WAL prefix to that
+// queue, and the SQS client must be set with WithSQSClient.
+//
+// Emission starts right after the cached length, so call Recover first.
+// Notifications may arrive late, out of order or more than once: records are
+// still emitted strictly in offset order and exactly once, and an offset
+// whose notification never shows up is read directly as soon as a later
+// offset is announced. Like in Follow, a missing offset is skipped as a gap
+// right away only below MinValidOffset, otherwise once it has been missing
+// for followGapGrace. Messages are deleted from the queue once parsed.
+func (w *S3WAL) FollowViaSQS(ctx context.Context, queueURL string) (<-chan Record, <-chan error) {
+	records := make(chan Record)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(errs)
+		defer close(records)
+
+		fail := func(err error) {
+			if ctx.Err() == nil {
+				errs <- err
+			}
+		}
+		if w.sqsClient == nil {
+			fail(errors.New("FollowViaSQS requires an SQS client, see WithSQSClient"))
+			return
+		}
+
+		next := w.NextOffset()
+
+		// offsets announced but not emitted yet; the map also absorbs duplicates
+		announced := make(map[uint64]struct{})
+		var gapSince time.Time // when next was first missing with later records
+		for {
+			out, err := w.sqsClient.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+				QueueUrl:            aws.String(queueURL),
+				MaxNumberOfMessages: 10,
+				WaitTimeSeconds:     20,
+			})
+			if err != nil {
+				fail(fmt.Errorf("receive messages: %w", err))
+				return
+			}
+			for _, msg := range out.Messages {
+				for _, key := range w.createdKeys(aws.ToString(msg.Body)) {
+					first, last, err := w.getRangeFromKey(key)
+					if err != nil {
+						continue
+					}
+					for offset := first; offset <= last; offset++ {
+						if offset >= next {
+							announced[offset] = struct{}{}
+						}
+					}
+				}
+				_, err := w.sqsClient.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+					QueueUrl:      aws.String(queueURL),
+					ReceiptHandle: msg.ReceiptHandle,
+				})
+				if err != nil {
+					fail(fmt.Errorf("delete message: %w", err))
+					return
+				}
+			}
+
+			// emit while the next offset is announced, or while a later offset
+			// is announced and next has to be read without its notification
+			for len(announced) > 0 {
+				rec, err := w.Read(ctx, next)
+				if err != nil {
+					if !isNotFound(err) {
+						fail(err)
+						return
+					}
+					// next is a gap if a later record already exists
+					existing, found, err := w.firstOffsetFrom(ctx, next)
+					if err != nil {
+						fail(err)
+						return
+					}
+					if !found {
+						// the announced objects are gone again, e.g. truncated
+						clear(announced)
+						break
+					}
+					if existing == next {
+						// created since the read; its notification is on the way
+						break
+					}
+					skipTo, skip, err := w.skipGap(ctx, next, existing, &gapSince)
+					if err != nil {
+						fail(err)
+						return
+					}
+					if !skip {
+						// possibly still being uploaded, look again after the next receive
+						break
+					}
+					for offset := next; offset < skipTo; offset++ {
+						delete(announced, offset)
+					}
+					next = skipTo
+					continue
+				}
+				select {
+				case records <- rec:
+				case <-ctx.Done():
+					return
+				}
+				delete(announced, next)
+				next++
+				gapSince = time.Time{}
+			}
+		}
+	}()
+
+	return records, errs
+}
+
+// createdKeys returns the WAL object keys announced as created by an SQS
+// message body holding an S3 event, optionally wrapped in an SNS envelope.
+func (w *S3WAL) createdKeys(body string) []string {
+	var event s3Event
+	if err := json.Unmarshal([]byte(body), &event); err != nil {
+		return nil
+	}
+	if len(event.Records) == 0 && event.Message != "" {
+		return w.createdKeys(event.Message)
+	}
+
+	prefix := w.listPrefix()
+	var keys []string
+	for _, r := range event.Records {
+		if !strings.HasPrefix(r.EventName, "ObjectCreated:") || r.S3.Bucket.Name != w.bucketName {
+			continue
+		}
+		// keys in event notifications are URL-encoded
+		key, err := url.QueryUnescape(r.S3.Object.Key)
+		if err != nil || !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
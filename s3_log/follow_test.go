@@ -0,0 +1,90 @@
+package s3_log
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// followN reads n records from Follow, failing the test on an error or if
+// they take longer than timeout.
+func followN(t *testing.T, records <-chan Record, errs <-chan error, n int, timeout time.Duration) []uint64 {
+	t.Helper()
+	var offsets []uint64
+	deadline := time.After(timeout)
+	for len(offsets) < n {
+		select {
+		case rec := <-records:
+			offsets = append(offsets, rec.Offset)
+		case err := <-errs:
+			t.Fatalf("Follow failed after %v: %v", offsets, err)
+		case <-deadline:
+			t.Fatalf("Follow delivered %v, want %d records", offsets, n)
+		}
+	}
+	return offsets
+}
+
+// A record stored after a later one, as concurrent BatchAppend uploads do,
+// must still be delivered rather than skipped as a gap.
+func TestFollowWaitsForInFlightOffset(t *testing.T) {
+	_, client := newFake(t)
+	w := NewS3WAL(client, "bucket", "wal", WithFollowInterval(10*time.Millisecond, 10*time.Millisecond))
+	defer w.Close()
+	for _, offset := range []uint64{1, 3} {
+		if err := w.AppendAt(bg, offset, []byte("data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(bg)
+	defer cancel()
+	records, errs := w.Follow(ctx, 1)
+	if got := followN(t, records, errs, 1, 5*time.Second); got[0] != 1 {
+		t.Fatalf("first record = %d, want 1", got[0])
+	}
+	time.Sleep(50 * time.Millisecond) // Follow polls for 2 with 3 present
+	if err := w.AppendAt(bg, 2, []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	if got := followN(t, records, errs, 2, 5*time.Second); got[0] != 2 || got[1] != 3 {
+		t.Fatalf("records = %v, want [2 3]", got)
+	}
+}
+
+// Offsets trimmed by retention are skipped at once; other gaps after
+// followGapGrace.
+func TestFollowSkipsGaps(t *testing.T) {
+	defer func(grace time.Duration) { followGapGrace = grace }(followGapGrace)
+	followGapGrace = 100 * time.Millisecond
+
+	f, client := newFake(t)
+	w := NewS3WAL(client, "bucket", "wal", WithFollowInterval(10*time.Millisecond, 10*time.Millisecond))
+	defer w.Close()
+	for _, offset := range []uint64{1, 2, 4} {
+		if err := w.AppendAt(bg, offset, []byte("data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f.setModified(w.getObjectKey(1), time.Now().Add(-time.Hour))
+	if err := w.TruncateBeforeTime(bg, time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(bg)
+	defer cancel()
+	start := time.Now()
+	records, errs := w.Follow(ctx, 1)
+	if got := followN(t, records, errs, 1, time.Second); got[0] != 2 {
+		t.Fatalf("first record = %d, want 2", got[0])
+	}
+	if elapsed := time.Since(start); elapsed >= followGapGrace {
+		t.Fatalf("trimmed offset skipped after %v, want at once", elapsed)
+	}
+	if got := followN(t, records, errs, 1, 5*time.Second); got[0] != 4 {
+		t.Fatalf("record after the gap = %d, want 4", got[0])
+	}
+	if elapsed := time.Since(start); elapsed < followGapGrace {
+		t.Fatalf("gap at 3 skipped after %v, want at least %v", elapsed, followGapGrace)
+	}
+}
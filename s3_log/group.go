@@ -0,0 +1,63 @@
+package s3_log
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// AppendGroup stores records as one object at consecutive offsets starting
+// at baseOffset, so that either all or none of them become visible. The
+// object uses the segment layout, a count and an index of record lengths
+// followed by the record bodies, and Read returns any single record of the
+// group as usual. Unlike WithSegmentation nothing is buffered: the group is
+// durable when AppendGroup returns. In segment mode buffered records are
+// flushed first to keep offsets in order.
+func (w *S3WAL) AppendGroup(ctx context.Context, records [][]byte) (baseOffset uint64, n int, err error) {
+	if len(records) == 0 {
+		return 0, 0, errors.New("s3_log: empty record group")
+	}
+	if w.allocator != nil {
+		return 0, 0, errors.New("s3_log: AppendGroup cannot be used with WithOffsetAllocator")
+	}
+	if records, err = w.applyAppendMiddlewareAll(ctx, records); err != nil {
+		return 0, 0, err
+	}
+	if err := w.beginOp(); err != nil {
+		return 0, 0, err
+	}
+	defer w.endOp()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.flushLocked(ctx); err != nil {
+		return 0, 0, fmt.Errorf("flush before group: %w", err)
+	}
+	base := w.length + 1
+	group := make([]Record, len(records))
+	for i, data := range records {
+		group[i] = Record{Offset: base + uint64(i), Data: data}
+	}
+	if w.hashChain {
+		prev, err := w.chainPrev(ctx, base)
+		if err != nil {
+			return 0, 0, err
+		}
+		group[0].PrevHash = prev
+	}
+	if w.dates != nil {
+		w.dates.startAt(base, time.Now())
+	}
+	lastBody, err := w.putSegment(ctx, group)
+	if err != nil {
+		return 0, 0, err
+	}
+	if w.hashChain {
+		w.setChainTail(group[len(group)-1].Offset, chainHash(lastBody))
+	}
+
+	w.length = base + uint64(len(records)) - 1
+	w.recoveredAt = time.Time{}
+	return base, len(records), nil
+}
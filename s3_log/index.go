@@ -0,0 +1,84 @@
+package s3_log
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// AppendKeyed appends data like Append and then records its offset under key
+// in a sidecar index, so that ReadByKey(key) returns it. A later AppendKeyed
+// with the same key replaces the entry.
+//
+// The index is written after the record and is not transactional with it: if
+// the index write fails, or the process dies in between, the record is in the
+// log but not reachable by key, and the error is returned with the offset.
+// Concurrent AppendKeyed calls with the same key leave whichever entry was
+// written last, which need not be the higher offset. Truncate does not touch
+// the index, so entries of truncated records dangle, and once their offsets
+// are appended again they point at unrelated records.
+func (w *S3WAL) AppendKeyed(ctx context.Context, key string, data []byte) (uint64, error) {
+	if key == "" {
+		return 0, errors.New("s3_log: empty record key")
+	}
+	offset, err := w.Append(ctx, data)
+	if err != nil {
+		return 0, err
+	}
+	indexKey := w.indexKey(key)
+	_, err = w.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:              aws.String(w.bucketName),
+		ACL:                 w.acl,
+		ExpectedBucketOwner: w.bucketOwner(),
+		RequestPayer:        w.requestPayer,
+		Key:                 aws.String(indexKey),
+		Body:                bytes.NewReader([]byte(strconv.FormatUint(offset, 10))),
+	}, s3Options(ctx)...)
+	if err != nil {
+		return offset, fmt.Errorf("put index %s: %w", indexKey, err)
+	}
+	return offset, nil
+}
+
+// ReadByKey returns the record last appended under key with AppendKeyed, or
+// ErrKeyNotFound. See AppendKeyed for how the index can lag the log.
+func (w *S3WAL) ReadByKey(ctx context.Context, key string) (Record, error) {
+	indexKey := w.indexKey(key)
+	out, err := w.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket:              aws.String(w.bucketName),
+		ExpectedBucketOwner: w.bucketOwner(),
+		RequestPayer:        w.requestPayer,
+		Key:                 aws.String(indexKey),
+	}, s3Options(ctx)...)
+	if err != nil {
+		if isNotFound(err) {
+			return Record{}, fmt.Errorf("key %q: %w", key, ErrKeyNotFound)
+		}
+		return Record{}, fmt.Errorf("get index %s: %w", indexKey, err)
+	}
+	defer out.Body.Close()
+
+	data, err := io.ReadAll(out.Body)
+	if err != nil {
+		return Record{}, fmt.Errorf("read index %s: %w", indexKey, err)
+	}
+	offset, err := strconv.ParseUint(string(data), 10, 64)
+	if err != nil {
+		return Record{}, fmt.Errorf("parse index %s: %w", indexKey, err)
+	}
+	return w.Read(ctx, offset)
+}
+
+// indexKey is the object holding the offset of the record appended under
+// key. Like the replication checkpoint it never parses as an offset, so
+// scans skip it.
+func (w *S3WAL) indexKey(key string) string {
+	return w.listPrefix() + "_index/" + url.PathEscape(key)
+}
@@ -0,0 +1,66 @@
+package s3_log
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Init is a one-call startup routine: it checks that the bucket exists and
+// is accessible, syncs the length with Recover and, unless WithoutInitProbe
+// is set, writes and deletes a probe object under the prefix to confirm
+// write and delete permission before the first Append needs them.
+func (w *S3WAL) Init(ctx context.Context) error {
+	if err := w.Validate(); err != nil {
+		return err
+	}
+	_, err := w.client.HeadBucket(ctx, &s3.HeadBucketInput{
+		Bucket:              aws.String(w.bucketName),
+		ExpectedBucketOwner: w.bucketOwner(),
+	}, s3Options(ctx)...)
+	if err != nil {
+		return fmt.Errorf("check bucket %s: %w", w.bucketName, err)
+	}
+	if _, err := w.Recover(ctx); err != nil {
+		return fmt.Errorf("recover: %w", err)
+	}
+	if w.noInitProbe {
+		return nil
+	}
+	return w.probeWrite(ctx)
+}
+
+// probeWrite writes an empty object to a unique reserved key and deletes it.
+func (w *S3WAL) probeWrite(ctx context.Context) error {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return fmt.Errorf("generate probe key: %w", err)
+	}
+	key := w.listPrefix() + "_probe/" + hex.EncodeToString(id)
+	_, err := w.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:              aws.String(w.bucketName),
+		ACL:                 w.acl,
+		ExpectedBucketOwner: w.bucketOwner(),
+		RequestPayer:        w.requestPayer,
+		Key:                 aws.String(key),
+		Body:                bytes.NewReader(nil),
+	}, s3Options(ctx)...)
+	if err != nil {
+		return fmt.Errorf("put probe object %s: %w", key, err)
+	}
+	_, err = w.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket:              aws.String(w.bucketName),
+		ExpectedBucketOwner: w.bucketOwner(),
+		RequestPayer:        w.requestPayer,
+		Key:                 aws.String(key),
+	}, s3Options(ctx)...)
+	if err != nil {
+		return fmt.Errorf("delete probe object %s: %w", key, err)
+	}
+	return nil
+}
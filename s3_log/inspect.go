@@ -0,0 +1,121 @@
+package s3_log
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// inspectHeaderMax covers a formatV1 header up to the compression header.
+const inspectHeaderMax = 10 + 5
+
+// FormatInfo describes the layout of a stored record body, as reported by
+// InspectFormat.
+type FormatInfo struct {
+	// Version is 0 for the original layout, [offset][data][sha256] or, with
+	// WithoutBodyChecksum, [offset][data], and 1 for the versioned one.
+	Version int
+	// Offset is the offset written in the body, which differs from the
+	// inspected one for a body copied to another key.
+	Offset uint64
+	// Checksum is where the SHA-256 of the body is; HasChecksum is false for
+	// WithoutBodyChecksum bodies, which have none.
+	Checksum    ChecksumPlacement
+	HasChecksum bool
+	// Compressed is set for zstd-compressed data, with the id of the
+	// WithZstdDictionary dictionary, 0 for none.
+	Compressed   bool
+	DictionaryID uint32
+	// Encrypted is set for WithClientEncryption bodies.
+	Encrypted bool
+	// Chained is set for bodies carrying the previous record's hash
+	// (WithHashChain).
+	Chained bool
+	// Segment is set for a record stored in a segment object.
+	Segment bool
+	// External is set for a WithExternalFormat body, of which nothing else
+	// is known.
+	External bool
+}
+
+// InspectFormat reports the layout of the record at offset without decoding
+// it, e.g. to find which options a log was written with, or why a Read of it
+// fails. Only the header is downloaded with a ranged GET, except for records
+// in segments, which are downloaded whole; nothing is validated. No layout
+// stores a timestamp, so there is none to report. Bodies of a WithRecordCodec
+// codec are not supported.
+func (w *S3WAL) InspectFormat(ctx context.Context, offset uint64) (FormatInfo, error) {
+	if err := w.beginOp(); err != nil {
+		return FormatInfo{}, err
+	}
+	defer w.endOp()
+	_, isChecksum := w.codec.(checksumCodec)
+	_, isPlain := w.codec.(plainCodec)
+	if !isChecksum && !isPlain {
+		return FormatInfo{}, errors.New("s3_log: InspectFormat does not support WithRecordCodec")
+	}
+
+	segment := false
+	data, _, err := w.getPrefix(ctx, w.getObjectKey(offset), inspectHeaderMax)
+	if err != nil && isNotFound(err) && w.reloadPartition(ctx, offset) {
+		data, _, err = w.getPrefix(ctx, w.getObjectKey(offset), inspectHeaderMax)
+	}
+	if err != nil {
+		if !isNotFound(err) {
+			return FormatInfo{}, err
+		}
+		if data, err = w.readRaw(ctx, offset); err != nil {
+			return FormatInfo{}, err
+		}
+		segment = true
+	}
+	info, err := w.inspectBody(data)
+	if err != nil {
+		return FormatInfo{}, fmt.Errorf("inspect offset %d: %w", offset, err)
+	}
+	info.Segment = segment
+	return info, nil
+}
+
+// inspectBody parses the header of a record body.
+func (w *S3WAL) inspectBody(data []byte) (FormatInfo, error) {
+	if w.externalDecode != nil && !isNativeBody(data) {
+		return FormatInfo{External: true}, nil
+	}
+	if len(data) > 0 && data[0] == formatV1 {
+		if len(data) < 10 {
+			return FormatInfo{}, ErrRecordTooShort
+		}
+		flags := data[1]
+		if flags&^knownFlags != 0 {
+			return FormatInfo{}, fmt.Errorf("unsupported record flags %#x", flags)
+		}
+		info := FormatInfo{
+			Version:     1,
+			Offset:      binary.BigEndian.Uint64(data[2:10]),
+			HasChecksum: true,
+			Compressed:  flags&flagCompressed != 0,
+			Encrypted:   flags&flagEncrypted != 0,
+			Chained:     flags&flagChained != 0,
+		}
+		if flags&flagChecksumHeader != 0 {
+			info.Checksum = ChecksumHeader
+		}
+		if info.Compressed {
+			if len(data) < 15 {
+				return FormatInfo{}, ErrRecordTooShort
+			}
+			info.DictionaryID = binary.BigEndian.Uint32(data[11:15])
+		}
+		return info, nil
+	}
+	if len(data) < 8 {
+		return FormatInfo{}, ErrRecordTooShort
+	}
+	_, isChecksum := w.codec.(checksumCodec)
+	return FormatInfo{
+		Offset:      binary.BigEndian.Uint64(data[:8]),
+		HasChecksum: isChecksum,
+	}, nil
+}
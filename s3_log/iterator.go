@@ -0,0 +1,158 @@
+package s3_log
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// IteratorOption configures Iterator.
+type IteratorOption func(*iteratorConfig)
+
+type iteratorConfig struct {
+	prefetch int
+}
+
+// WithIteratorPrefetch makes the iterator download up to n objects ahead,
+// concurrently, while the caller processes the current one. Records are
+// still yielded in offset order; at most n+1 objects are held in memory.
+// Under WithAdaptiveConcurrency the downloads in flight follow its level
+// within that window. The default, 0, downloads one object at a time when it
+// is needed.
+func WithIteratorPrefetch(n int) IteratorOption {
+	if n < 0 {
+		panic(fmt.Sprintf("s3_log: invalid iterator prefetch %d", n))
+	}
+	return func(c *iteratorConfig) {
+		c.prefetch = n
+	}
+}
+
+// Iterator walks the stored records in offset order, see S3WAL.Iterator.
+type Iterator struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	fetches chan *iteratorFetch
+
+	cur []Record
+	pos int
+	rec Record
+	err error
+}
+
+// iteratorFetch is the download of one object, done once done is closed.
+type iteratorFetch struct {
+	done    chan struct{}
+	records []Record
+	err     error
+}
+
+// Iterator returns an iterator over the stored records from offset from
+// (inclusive) onwards, in offset order. It lists the WAL as it goes and sees
+// the records stored at that time; buffered records are not included. Call
+// Close when done with it.
+//
+//	it := w.Iterator(ctx, 1, s3_log.WithIteratorPrefetch(8))
+//	defer it.Close()
+//	for it.Next() {
+//		process(it.Record())
+//	}
+//	if err := it.Err(); err != nil { ... }
+func (w *S3WAL) Iterator(ctx context.Context, from uint64, opts ...IteratorOption) *Iterator {
+	var cfg iteratorConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	it := &Iterator{
+		ctx:     ctx,
+		cancel:  cancel,
+		fetches: make(chan *iteratorFetch, cfg.prefetch),
+	}
+
+	// the window already bounds the downloads unless the level is adaptive
+	slots := w.readLimiter(cfg.prefetch + 1)
+	go func() {
+		defer close(it.fetches)
+		err := w.walkObjects(ctx, func(obj types.Object, first, last uint64) error {
+			if last < from {
+				return nil
+			}
+			f := &iteratorFetch{done: make(chan struct{})}
+			// blocks while the window is full
+			select {
+			case it.fetches <- f:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			go func() {
+				defer close(f.done)
+				if err := slots.acquire(ctx); err != nil {
+					f.err = err
+					return
+				}
+				start := time.Now()
+				records, err := w.readObject(ctx, aws.ToString(obj.Key), first, last)
+				slots.release(start, err)
+				for len(records) > 0 && records[0].Offset < from {
+					records = records[1:]
+				}
+				f.records, f.err = records, err
+			}()
+			return nil
+		})
+		if err != nil && ctx.Err() == nil {
+			f := &iteratorFetch{done: make(chan struct{}), err: err}
+			close(f.done)
+			select {
+			case it.fetches <- f:
+			case <-ctx.Done():
+			}
+		}
+	}()
+	return it
+}
+
+// Next advances to the next record and reports whether there is one. It
+// returns false at the end of the WAL and after an error, see Err.
+func (it *Iterator) Next() bool {
+	for it.pos >= len(it.cur) {
+		if it.err != nil {
+			return false
+		}
+		f, ok := <-it.fetches
+		if !ok {
+			// the listing ends early when ctx is cancelled
+			it.err = it.ctx.Err()
+			return false
+		}
+		<-f.done
+		if f.err != nil {
+			it.err = f.err
+			it.cancel()
+			return false
+		}
+		it.cur, it.pos = f.records, 0
+	}
+	it.rec = it.cur[it.pos]
+	it.pos++
+	return true
+}
+
+// Record returns the record Next advanced to.
+func (it *Iterator) Record() Record {
+	return it.rec
+}
+
+// Err returns the error that ended the iteration, if any.
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// Close stops the iteration and any downloads in progress.
+func (it *Iterator) Close() {
+	it.cancel()
+}
@@ -0,0 +1,199 @@
+package s3_log
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// journalFile is the name of the local journal in the WithLocalJournal dir.
+const journalFile = "journal"
+
+// journalHeaderSize is the size of an entry header: offset, data length and
+// a CRC-32C of both and the data.
+const journalHeaderSize = 8 + 4 + 4
+
+var journalTable = crc32.MakeTable(crc32.Castagnoli)
+
+// localJournal is an append-only file of the records acknowledged to callers
+// but not yet confirmed by S3. Entries for an offset written later replace
+// earlier ones. It is emptied whenever everything in it reached S3. Callers
+// must hold w.mu.
+type localJournal struct {
+	f       *os.File
+	size    int64 // end of the last complete entry
+	prev    int64 // size before the last append, for undo
+	initErr error // reported by Validate; the journal is not written
+}
+
+// newLocalJournal creates dir if needed and opens the journal in it, cutting
+// off an entry torn by a crash during its write.
+func newLocalJournal(dir string) *localJournal {
+	j := &localJournal{}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		j.initErr = fmt.Errorf("create journal dir: %w", err)
+		return j
+	}
+	f, err := os.OpenFile(filepath.Join(dir, journalFile), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		j.initErr = fmt.Errorf("open journal: %w", err)
+		return j
+	}
+	j.f = f
+	_, size, err := j.load()
+	if err != nil {
+		j.initErr = err
+		return j
+	}
+	if err := f.Truncate(size); err != nil {
+		j.initErr = fmt.Errorf("truncate journal: %w", err)
+		return j
+	}
+	j.size, j.prev = size, size
+	return j
+}
+
+// append durably adds a record to the journal.
+func (j *localJournal) append(offset uint64, data []byte) error {
+	if j.f == nil {
+		return j.initErr
+	}
+	entry := make([]byte, journalHeaderSize+len(data))
+	binary.BigEndian.PutUint64(entry[0:8], offset)
+	binary.BigEndian.PutUint32(entry[8:12], uint32(len(data)))
+	copy(entry[journalHeaderSize:], data)
+	crc := crc32.Update(crc32.Checksum(entry[0:12], journalTable), journalTable, data)
+	binary.BigEndian.PutUint32(entry[12:16], crc)
+
+	if _, err := j.f.WriteAt(entry, j.size); err != nil {
+		_ = j.f.Truncate(j.size)
+		return fmt.Errorf("write journal: %w", err)
+	}
+	if err := j.f.Sync(); err != nil {
+		_ = j.f.Truncate(j.size)
+		return fmt.Errorf("sync journal: %w", err)
+	}
+	j.prev = j.size
+	j.size += int64(len(entry))
+	return nil
+}
+
+// undo removes the entry added by the last append, for a record that was
+// not acknowledged after all.
+func (j *localJournal) undo() {
+	if j.f == nil || j.prev == j.size {
+		return
+	}
+	if err := j.f.Truncate(j.prev); err == nil {
+		j.size = j.prev
+	}
+}
+
+// reset replaces the journal's content with records, the ones still
+// unconfirmed. It is best effort: entries left behind for records that did
+// reach S3 are skipped by ReplayJournal.
+func (j *localJournal) reset(records []Record) {
+	if j.f == nil || (j.size == 0 && len(records) == 0) {
+		return
+	}
+	if err := j.f.Truncate(0); err != nil {
+		return
+	}
+	j.size, j.prev = 0, 0
+	for _, rec := range records {
+		if err := j.append(rec.Offset, rec.Data); err != nil {
+			return
+		}
+	}
+	j.prev = j.size
+}
+
+// load parses the journal and returns its records in offset order, the last
+// entry for each offset, and the size of the complete entries.
+func (j *localJournal) load() ([]Record, int64, error) {
+	if j.f == nil {
+		return nil, 0, j.initErr
+	}
+	info, err := j.f.Stat()
+	if err != nil {
+		return nil, 0, fmt.Errorf("stat journal: %w", err)
+	}
+	buf := make([]byte, info.Size())
+	if _, err := j.f.ReadAt(buf, 0); err != nil && len(buf) > 0 {
+		return nil, 0, fmt.Errorf("read journal: %w", err)
+	}
+
+	byOffset := make(map[uint64][]byte)
+	var size int64
+	for rest := buf; len(rest) >= journalHeaderSize; {
+		n32 := binary.BigEndian.Uint32(rest[8:12])
+		if uint64(len(rest)-journalHeaderSize) < uint64(n32) {
+			break
+		}
+		n := int(n32)
+		data := rest[journalHeaderSize : journalHeaderSize+n]
+		crc := crc32.Update(crc32.Checksum(rest[0:12], journalTable), journalTable, data)
+		if crc != binary.BigEndian.Uint32(rest[12:16]) {
+			// torn write: nothing after it was acknowledged
+			break
+		}
+		byOffset[binary.BigEndian.Uint64(rest[0:8])] = data
+		rest = rest[journalHeaderSize+n:]
+		size += int64(journalHeaderSize + n)
+	}
+
+	records := make([]Record, 0, len(byOffset))
+	for offset, data := range byOffset {
+		records = append(records, Record{Offset: offset, Data: data})
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Offset < records[j].Offset })
+	return records, size, nil
+}
+
+// ReplayJournal stores the records of the WithLocalJournal journal that are
+// missing from S3, each at its original offset, and then clears the journal.
+// It returns how many records it stored. Call it on startup, before the
+// first Append, to recover records a crash lost between being acknowledged
+// and reaching S3, such as those buffered by WithSegmentation. Records found
+// in S3, in an object or a segment, are left alone. It is a no-op without
+// WithLocalJournal.
+func (w *S3WAL) ReplayJournal(ctx context.Context) (int, error) {
+	if w.journal == nil {
+		return 0, nil
+	}
+	w.mu.Lock()
+	records, _, err := w.journal.load()
+	w.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	replayed := 0
+	for _, rec := range records {
+		_, err := w.Read(ctx, rec.Offset)
+		if err == nil {
+			continue
+		}
+		if !isNotFound(err) {
+			return replayed, fmt.Errorf("check journal record %d: %w", rec.Offset, err)
+		}
+		// the journal holds data the middleware already transformed
+		if err := w.appendAt(ctx, rec.Offset, rec.Data, true, false); err != nil {
+			if errors.Is(err, ErrOffsetExists) {
+				continue
+			}
+			return replayed, fmt.Errorf("replay journal record %d: %w", rec.Offset, err)
+		}
+		replayed++
+	}
+
+	w.mu.Lock()
+	w.journal.reset(w.pending)
+	w.mu.Unlock()
+	return replayed, nil
+}
@@ -0,0 +1,88 @@
+package s3_log
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// KeyFormat describes how offsets are written into object keys, see
+// WithKeyFormat. The zero value is the default layout: a "/" separator and
+// offsets in decimal, zero-padded to 20 digits.
+type KeyFormat struct {
+	// Radix of the offset digits, 2 to 36; 0 means 10.
+	Radix int
+	// Width the offset is zero-padded to. It must fit the largest offset, so
+	// that every name has the same length and sorts in offset order; 0 means
+	// just that width.
+	Width int
+	// Uppercase writes the digits past 9 as A-Z instead of a-z.
+	Uppercase bool
+	// Separator between the prefix and the offset; "" keeps the current
+	// one. It must not contain digits, nor letters that are digits of the
+	// radix.
+	Separator string
+}
+
+// normalize fills in the defaults of f and checks that it gives fixed-width
+// names that sort in offset order and start after an unambiguous separator.
+func (f KeyFormat) normalize() (KeyFormat, error) {
+	if f.Radix == 0 {
+		f.Radix = 10
+	}
+	if f.Radix < 2 || f.Radix > 36 {
+		return f, fmt.Errorf("radix %d not between 2 and 36", f.Radix)
+	}
+	minWidth := len(strconv.FormatUint(math.MaxUint64, f.Radix))
+	if f.Width == 0 {
+		f.Width = minWidth
+	}
+	if f.Width < minWidth {
+		// larger offsets would need more digits and sort before smaller ones
+		return f, fmt.Errorf("width %d below the %d digits of the largest offset in radix %d", f.Width, minWidth, f.Radix)
+	}
+	for _, c := range strings.ToLower(f.Separator) {
+		if digitValue(c) < max(f.Radix, 10) {
+			return f, fmt.Errorf("separator %q contains the digit %q", f.Separator, c)
+		}
+	}
+	return f, nil
+}
+
+// format returns the name of offset under f, which must be normalized.
+func (f KeyFormat) format(offset uint64) string {
+	s := strconv.FormatUint(offset, f.Radix)
+	if f.Uppercase {
+		s = strings.ToUpper(s)
+	}
+	return strings.Repeat("0", f.Width-len(s)) + s
+}
+
+// parse reverses format. Names of another width or letter case are rejected,
+// so that every offset has exactly one key.
+func (f KeyFormat) parse(name string) (uint64, error) {
+	if len(name) != f.Width {
+		return 0, fmt.Errorf("invalid offset %q: want %d digits", name, f.Width)
+	}
+	for _, c := range name {
+		if digitValue(c) >= f.Radix || (c >= 'a' && c <= 'z' && f.Uppercase) || (c >= 'A' && c <= 'Z' && !f.Uppercase) {
+			return 0, fmt.Errorf("invalid offset %q: not radix %d", name, f.Radix)
+		}
+	}
+	return strconv.ParseUint(name, f.Radix, 64)
+}
+
+// digitValue is the value of c as a digit of any radix up to 36, or 36 if c
+// is not one.
+func digitValue(c rune) int {
+	switch {
+	case c >= '0' && c <= '9':
+		return int(c - '0')
+	case c >= 'a' && c <= 'z':
+		return int(c-'a') + 10
+	case c >= 'A' && c <= 'Z':
+		return int(c-'A') + 10
+	}
+	return 36
+}
@@ -0,0 +1,95 @@
+package s3_log
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// defaultLastOffsetsConcurrency bounds the streams LastOffsets recovers at
+// once.
+const defaultLastOffsetsConcurrency = 8
+
+// LastOffsets finds the WALs stored directly below rootPrefix, one per
+// "directory" as listed with Delimiter "/", and returns the last offset of
+// each, keyed by its prefix as NewS3WAL takes it, e.g. "tenants/acme" for
+// rootPrefix "tenants". Up to defaultLastOffsetsConcurrency streams are
+// recovered at once; a prefix without records maps to 0. opts must describe
+// the layout the WALs were written with when it differs from the default. The
+// first failure cancels the others and is returned.
+func LastOffsets(ctx context.Context, client *s3.Client, bucket, rootPrefix string, opts ...Option) (map[string]uint64, error) {
+	listPrefix := strings.Trim(rootPrefix, "/")
+	if listPrefix != "" {
+		listPrefix += "/"
+	}
+	// for the options that apply to every request, such as WithRequestPayer
+	opt := newS3WAL(sdkClient(client), bucket, rootPrefix, opts)
+	var prefixes []string
+	paginator := s3.NewListObjectsV2Paginator(opt.client, &s3.ListObjectsV2Input{
+		Bucket:              aws.String(bucket),
+		ExpectedBucketOwner: opt.bucketOwner(),
+		RequestPayer:        opt.requestPayer,
+		Prefix:              aws.String(listPrefix),
+		Delimiter:           aws.String("/"),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx, s3Options(ctx)...)
+		if err != nil {
+			return nil, fmt.Errorf("list streams under %q: %w", listPrefix, err)
+		}
+		for _, cp := range page.CommonPrefixes {
+			if p := strings.Trim(aws.ToString(cp.Prefix), "/"); p != "" {
+				prefixes = append(prefixes, p)
+			}
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	lengths := make([]uint64, len(prefixes))
+	var firstErr error
+	var failOnce sync.Once
+	sem := make(chan struct{}, defaultLastOffsetsConcurrency)
+	var wg sync.WaitGroup
+	for i, prefix := range prefixes {
+		sem <- struct{}{}
+		if ctx.Err() != nil {
+			<-sem
+			break
+		}
+		wg.Add(1)
+		go func(i int, prefix string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			// no background work is started, so there is nothing to close
+			length, err := newS3WAL(sdkClient(client), bucket, prefix, opts).Recover(ctx)
+			if err != nil {
+				failOnce.Do(func() {
+					firstErr = fmt.Errorf("stream %q: %w", prefix, err)
+					cancel()
+				})
+				return
+			}
+			lengths[i] = length
+		}(i, prefix)
+	}
+	wg.Wait()
+	if firstErr == nil {
+		// cancelled by the caller
+		firstErr = ctx.Err()
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	offsets := make(map[string]uint64, len(prefixes))
+	for i, prefix := range prefixes {
+		offsets[prefix] = lengths[i]
+	}
+	return offsets, nil
+}
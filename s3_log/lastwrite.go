@@ -0,0 +1,44 @@
+package s3_log
+
+import "bytes"
+
+// setLastWrite remembers rec, the record Append just stored, under
+// WithLastWriteCache.
+func (w *S3WAL) setLastWrite(rec Record) {
+	if !w.lastWriteCache {
+		return
+	}
+	rec.Data = bytes.Clone(rec.Data)
+	w.lastWriteMu.Lock()
+	w.lastWrite = rec
+	w.lastWriteMu.Unlock()
+}
+
+// cachedLastWrite returns the remembered record if it is the one at offset.
+func (w *S3WAL) cachedLastWrite(offset uint64) (Record, bool) {
+	if !w.lastWriteCache {
+		return Record{}, false
+	}
+	w.lastWriteMu.Lock()
+	defer w.lastWriteMu.Unlock()
+	if w.lastWrite.Offset == 0 || w.lastWrite.Offset != offset {
+		return Record{}, false
+	}
+	rec := w.lastWrite
+	// callers may modify what Read returns
+	rec.Data = bytes.Clone(rec.Data)
+	return rec, true
+}
+
+// dropLastWrite forgets the remembered record if its offset is in
+// first..last, because the object stored there was replaced or deleted.
+func (w *S3WAL) dropLastWrite(first, last uint64) {
+	if !w.lastWriteCache {
+		return
+	}
+	w.lastWriteMu.Lock()
+	if w.lastWrite.Offset >= first && w.lastWrite.Offset <= last {
+		w.lastWrite = Record{}
+	}
+	w.lastWriteMu.Unlock()
+}
@@ -0,0 +1,78 @@
+package s3_log
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"golang.org/x/sync/semaphore"
+)
+
+// limitOptions adds the WithMaxConcurrentOps limiter to a client's requests.
+func (w *S3WAL) limitOptions(o *s3.Options) {
+	if w.opSlots == nil {
+		return
+	}
+	o.APIOptions = append(o.APIOptions, func(stack *middleware.Stack) error {
+		// after the deserializer, so the slot is taken per attempt around the
+		// transport and retry backoff does not hold one
+		return stack.Deserialize.Add(&limitMiddleware{slots: w.opSlots}, middleware.After)
+	})
+}
+
+// limitedClient returns a copy of client whose requests take a slot. Other
+// S3API implementations are returned as they are; Validate reports them.
+func (w *S3WAL) limitedClient(client S3API) S3API {
+	c, ok := client.(*s3.Client)
+	if !ok || w.opSlots == nil {
+		return client
+	}
+	return s3.New(c.Options(), w.limitOptions)
+}
+
+// sdkClient converts client to an S3API, keeping nil a nil interface so that
+// nil checks of the fields holding it keep working.
+func sdkClient(client *s3.Client) S3API {
+	if client == nil {
+		return nil
+	}
+	return client
+}
+
+// limitMiddleware holds a slot from sending a request until its response
+// body is closed, which for GetObject is after the caller read it.
+type limitMiddleware struct {
+	slots *semaphore.Weighted
+}
+
+func (*limitMiddleware) ID() string { return "S3WALConcurrencyLimit" }
+
+func (m *limitMiddleware) HandleDeserialize(ctx context.Context, in middleware.DeserializeInput, next middleware.DeserializeHandler) (middleware.DeserializeOutput, middleware.Metadata, error) {
+	if err := m.slots.Acquire(ctx, 1); err != nil {
+		return middleware.DeserializeOutput{}, middleware.Metadata{}, err
+	}
+	out, md, err := next.HandleDeserialize(ctx, in)
+	resp, ok := out.RawResponse.(*smithyhttp.Response)
+	if err != nil || !ok || resp.Body == nil {
+		m.slots.Release(1)
+		return out, md, err
+	}
+	resp.Body = &releaseOnClose{ReadCloser: resp.Body, release: func() { m.slots.Release(1) }}
+	return out, md, err
+}
+
+// releaseOnClose calls release once, when the body is closed.
+type releaseOnClose struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (r *releaseOnClose) Close() error {
+	err := r.ReadCloser.Close()
+	r.once.Do(r.release)
+	return err
+}
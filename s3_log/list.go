@@ -0,0 +1,190 @@
+package s3_log
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// ListPage returns the offsets stored in one page of at most limit objects,
+// starting at token ("" for the first page), and the token of the next page,
+// which is "" once the listing is exhausted. It issues a single
+// ListObjectsV2 call; a segment object contributes every offset it holds, so
+// a page can hold more than limit offsets. Buffered, not yet flushed records
+// are not included.
+func (w *S3WAL) ListPage(ctx context.Context, token string, limit int) (offsets []uint64, nextToken string, err error) {
+	if limit <= 0 || limit > 1000 {
+		// S3 never returns more than 1000 keys per call
+		limit = 1000
+	}
+	input := &s3.ListObjectsV2Input{
+		Bucket:              aws.String(w.bucketName),
+		ExpectedBucketOwner: w.bucketOwner(),
+		RequestPayer:        w.requestPayer,
+		Prefix:              aws.String(w.listPrefix()),
+		Delimiter:           w.listDelimiter(),
+		MaxKeys:             aws.Int32(int32(limit)),
+	}
+	if token != "" {
+		input.ContinuationToken = aws.String(token)
+	}
+	out, err := w.client.ListObjectsV2(ctx, input, s3Options(ctx)...)
+	if err != nil {
+		return nil, "", fmt.Errorf("list objects: %w", err)
+	}
+	for _, obj := range out.Contents {
+		if obj.Key == nil {
+			continue
+		}
+		first, last, err := w.getRangeFromKey(*obj.Key)
+		if err != nil {
+			// ignore keys that don't match pattern
+			continue
+		}
+		for offset := first; offset <= last; offset++ {
+			offsets = append(offsets, offset)
+		}
+	}
+	if aws.ToBool(out.IsTruncated) {
+		nextToken = aws.ToString(out.NextContinuationToken)
+	}
+	return offsets, nextToken, nil
+}
+
+// ScanStats describes the listing done by a full scan of the WAL's objects.
+type ScanStats struct {
+	Pages int // ListObjectsV2 calls
+	Keys  int // keys returned, including those that are not WAL objects
+}
+
+// addPage counts one listing page; s may be nil.
+func (s *ScanStats) addPage(page *s3.ListObjectsV2Output) {
+	if s == nil {
+		return
+	}
+	s.Pages++
+	s.Keys += len(page.Contents)
+}
+
+// eachObject calls fn for every WAL object in key order with the offsets it
+// covers. Keys that do not parse are skipped.
+func (w *S3WAL) eachObject(ctx context.Context, fn func(obj types.Object, first, last uint64) error) error {
+	return w.scanObjects(ctx, nil, fn)
+}
+
+// scanObjects is eachObject that counts the listing in stats, which may be nil.
+func (w *S3WAL) scanObjects(ctx context.Context, stats *ScanStats, fn func(obj types.Object, first, last uint64) error) error {
+	input := &s3.ListObjectsV2Input{
+		Bucket:              aws.String(w.bucketName),
+		ExpectedBucketOwner: w.bucketOwner(),
+		RequestPayer:        w.requestPayer,
+		Prefix:              aws.String(w.listPrefix()),
+		Delimiter:           w.listDelimiter(),
+	}
+	paginator := s3.NewListObjectsV2Paginator(w.client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx, s3Options(ctx)...)
+		if err != nil {
+			return fmt.Errorf("list objects: %w", err)
+		}
+		stats.addPage(page)
+		for _, obj := range page.Contents {
+			if obj.Key == nil {
+				continue
+			}
+			first, last, err := w.getRangeFromKey(*obj.Key)
+			if err != nil {
+				continue
+			}
+			if err := fn(obj, first, last); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// walkObjects is eachObject in offset order. Key order is offset order unless
+// WithHashPrefix is set, in which case all keys are listed and sorted first.
+func (w *S3WAL) walkObjects(ctx context.Context, fn func(obj types.Object, first, last uint64) error) error {
+	if w.hashChars == 0 {
+		return w.eachObject(ctx, fn)
+	}
+
+	type object struct {
+		obj         types.Object
+		first, last uint64
+	}
+	var objects []object
+	err := w.eachObject(ctx, func(obj types.Object, first, last uint64) error {
+		objects = append(objects, object{obj, first, last})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].first < objects[j].first })
+	for _, o := range objects {
+		if err := fn(o.obj, o.first, o.last); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// errStopWalk ends a walk early without reporting an error.
+var errStopWalk = errors.New("stop walk")
+
+// CountUpTo walks the stored records in offset order and reports how many fit
+// in byteBudget bytes of S3 object size, and the offset of the last of them,
+// e.g. to size replay batches. A segment counts as a whole. count is 0 if the
+// first object alone exceeds the budget. Buffered records are not included.
+func (w *S3WAL) CountUpTo(ctx context.Context, byteBudget int64) (count int, lastOffset uint64, err error) {
+	var used int64
+	err = w.walkObjects(ctx, func(obj types.Object, first, last uint64) error {
+		size := aws.ToInt64(obj.Size)
+		if used+size > byteBudget {
+			return errStopWalk
+		}
+		used += size
+		count += int(last - first + 1)
+		lastOffset = last
+		return nil
+	})
+	if errors.Is(err, errStopWalk) {
+		err = nil
+	}
+	return count, lastOffset, err
+}
+
+// CheckContiguous verifies that the stored offsets are exactly 1..max, as
+// they are for a log that only grows through Append and shrinks through
+// Truncate. If not, it returns the first missing offset. Overlapping objects,
+// e.g. a record stored both on its own and in a segment, are not a gap. An
+// empty WAL is contiguous.
+func (w *S3WAL) CheckContiguous(ctx context.Context) (firstGap uint64, ok bool, err error) {
+	type span struct{ first, last uint64 }
+	var spans []span
+	err = w.eachObject(ctx, func(_ types.Object, first, last uint64) error {
+		spans = append(spans, span{first, last})
+		return nil
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].first < spans[j].first })
+
+	var covered uint64 // offsets 1..covered are present
+	for _, s := range spans {
+		if s.first > covered+1 {
+			return covered + 1, false, nil
+		}
+		covered = max(covered, s.last)
+	}
+	return 0, true, nil
+}
@@ -0,0 +1,105 @@
+package s3_log
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// MaintenanceConfig selects the work StartMaintenance does on each run.
+type MaintenanceConfig struct {
+	// Interval is the time between runs; the first run is one Interval
+	// after StartMaintenance.
+	Interval time.Duration
+	// RetainDuration makes each run call TruncateBeforeTime with a cutoff
+	// of RetainDuration ago, except that the newest record is always kept so
+	// that the next offset survives an idle period longer than
+	// RetainDuration. 0 disables it.
+	RetainDuration time.Duration
+	// CoalesceThreshold makes each run call Coalesce with it as the group
+	// size. 0 disables it. Coalesced records keep their age for
+	// RetainDuration.
+	CoalesceThreshold int
+}
+
+// StartMaintenance runs retention and coalescing in the background every
+// cfg.Interval until ctx is done or Close is called. Each task of a run is
+// reported to the WithObserver observers as OpRetention or OpCoalesce with
+// its duration and error, so failures show up in metrics and logs; a failed
+// run is simply retried on the next one. Coalescing is safe alongside
+// Appends, but not alongside Truncate, Overwrite or AppendAt by any writer.
+// Only one maintenance loop runs per S3WAL.
+func (w *S3WAL) StartMaintenance(ctx context.Context, cfg MaintenanceConfig) error {
+	if cfg.Interval <= 0 {
+		return fmt.Errorf("s3_log: invalid maintenance interval %v", cfg.Interval)
+	}
+	if cfg.RetainDuration < 0 {
+		return fmt.Errorf("s3_log: invalid retain duration %v", cfg.RetainDuration)
+	}
+	if cfg.CoalesceThreshold < 0 || cfg.CoalesceThreshold == 1 {
+		return fmt.Errorf("s3_log: invalid coalesce threshold %d", cfg.CoalesceThreshold)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.maintStop != nil {
+		return errors.New("s3_log: maintenance already running")
+	}
+	w.maintStop = make(chan struct{})
+	w.maintDone = make(chan struct{})
+	go w.runMaintenance(ctx, cfg, w.maintStop, w.maintDone)
+	return nil
+}
+
+// stopMaintenance stops the StartMaintenance loop and waits for a run in
+// progress to finish.
+func (w *S3WAL) stopMaintenance() {
+	w.mu.Lock()
+	stop, done := w.maintStop, w.maintDone
+	w.maintStop, w.maintDone = nil, nil
+	w.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+func (w *S3WAL) runMaintenance(ctx context.Context, cfg MaintenanceConfig, stop, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ctx.Done():
+			w.mu.Lock()
+			if w.maintStop == stop {
+				w.maintStop, w.maintDone = nil, nil
+			}
+			w.mu.Unlock()
+			return
+		case <-ticker.C:
+		}
+		if cfg.RetainDuration > 0 {
+			start := time.Now()
+			err := w.retainSince(ctx, start.Add(-cfg.RetainDuration))
+			w.observe(OpRetention, start, &err)
+		}
+		if cfg.CoalesceThreshold > 0 {
+			start := time.Now()
+			err := w.Coalesce(ctx, cfg.CoalesceThreshold)
+			w.observe(OpCoalesce, start, &err)
+		}
+	}
+}
+
+// retainSince is the maintenance retention: TruncateBeforeTime keeping the
+// newest record.
+func (w *S3WAL) retainSince(ctx context.Context, cutoff time.Time) error {
+	if err := w.beginOp(); err != nil {
+		return err
+	}
+	defer w.endOp()
+	return w.truncateBeforeTime(ctx, cutoff, true)
+}
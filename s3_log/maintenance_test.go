@@ -0,0 +1,44 @@
+package s3_log
+
+import (
+	"testing"
+	"time"
+)
+
+// Maintenance retention deletes old records but keeps the newest, so that
+// the WAL's length survives idle periods longer than RetainDuration.
+func TestMaintenanceRetentionKeepsNewestRecord(t *testing.T) {
+	f, client := newFake(t)
+	w := NewS3WAL(client, "bucket", "wal")
+	defer w.Close()
+	for i := 0; i < 3; i++ {
+		if _, err := w.Append(bg, []byte("data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, k := range f.keys() {
+		f.setModified(k, time.Now().Add(-time.Hour))
+	}
+
+	err := w.StartMaintenance(bg, MaintenanceConfig{Interval: 10 * time.Millisecond, RetainDuration: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(f.keys()) > 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	// let another run find nothing more to delete
+	time.Sleep(50 * time.Millisecond)
+	w.stopMaintenance()
+
+	if keys := f.keys(); len(keys) != 2 || keys[0] != w.getObjectKey(3) || keys[1] != w.trimMarkerKey() {
+		t.Fatalf("keys after retention = %q, want record 3 and the trim marker", keys)
+	}
+	restarted := NewS3WAL(client, "bucket", "wal")
+	defer restarted.Close()
+	length, err := restarted.Recover(bg)
+	if err != nil || length != 3 {
+		t.Fatalf("Recover = %d, %v, want 3", length, err)
+	}
+}
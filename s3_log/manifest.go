@@ -0,0 +1,208 @@
+package s3_log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// manifestPoll is how often an instance waiting for another one's listing
+// checks the manifest again.
+const manifestPoll = 250 * time.Millisecond
+
+// manifestConfig is the WithManifest configuration.
+type manifestConfig struct {
+	ttl   time.Duration // how long a manifest is trusted
+	lease time.Duration // after which a lease is considered abandoned
+}
+
+// manifest is the shared result of the last listing Recover did.
+type manifest struct {
+	Length  uint64    `json:"length"`
+	Updated time.Time `json:"updated"`
+}
+
+// recoverShared is the listing behind Recover: through the manifest under
+// WithManifest, directly otherwise.
+func (w *S3WAL) recoverShared(ctx context.Context) (uint64, error) {
+	if w.manifest == nil {
+		return w.recover(ctx, nil)
+	}
+	return w.recoverManifest(ctx)
+}
+
+// recoverManifest sets w.length from a fresh manifest if there is one.
+// Otherwise the instance that creates the lease object lists and writes the
+// manifest, while the others wait for it. A lease older than the configured
+// lease duration was left by a crashed instance and is broken.
+func (w *S3WAL) recoverManifest(ctx context.Context) (uint64, error) {
+	if err := w.beginOp(); err != nil {
+		return 0, err
+	}
+	defer w.endOp()
+
+	for {
+		m, err := w.loadManifest(ctx)
+		if err != nil {
+			return 0, err
+		}
+		if m != nil && time.Since(m.Updated) < w.manifest.ttl {
+			if length, ok := w.adoptManifest(ctx, m.Length); ok {
+				return length, nil
+			}
+		}
+
+		etag, err := w.acquireManifestLease(ctx)
+		if err != nil {
+			return 0, err
+		}
+		if etag != "" {
+			length, err := w.recover(ctx, nil)
+			if err == nil {
+				err = w.saveManifest(ctx, length)
+			}
+			w.deleteManifestLease(ctx, etag)
+			return length, err
+		}
+
+		// another instance is listing
+		if err := sleepCtx(ctx, manifestPoll); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// adoptManifest takes length from a manifest after checking that its last
+// record still exists and moving past records appended since it was written.
+// It reports false if the WAL was truncated since.
+func (w *S3WAL) adoptManifest(ctx context.Context, length uint64) (uint64, bool) {
+	if length > 0 && !w.objectExists(ctx, w.getObjectKey(length)) {
+		return 0, false
+	}
+	for w.objectExists(ctx, w.getObjectKey(length+1)) {
+		length++
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.length = length
+	w.recoveredAt = time.Now()
+	return length, true
+}
+
+// loadManifest returns the manifest, or nil if there is none or it cannot
+// be parsed; a damaged manifest is rewritten by the next listing.
+func (w *S3WAL) loadManifest(ctx context.Context) (*manifest, error) {
+	key := w.manifestKey()
+	out, err := w.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket:              aws.String(w.bucketName),
+		ExpectedBucketOwner: w.bucketOwner(),
+		RequestPayer:        w.requestPayer,
+		Key:                 aws.String(key),
+	}, s3Options(ctx)...)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("get manifest %s: %w", key, err)
+	}
+	defer out.Body.Close()
+
+	data, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read manifest %s: %w", key, err)
+	}
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, nil
+	}
+	return &m, nil
+}
+
+func (w *S3WAL) saveManifest(ctx context.Context, length uint64) error {
+	data, err := json.Marshal(manifest{Length: length, Updated: time.Now().UTC()})
+	if err != nil {
+		return fmt.Errorf("encode manifest: %w", err)
+	}
+	key := w.manifestKey()
+	_, err = w.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:              aws.String(w.bucketName),
+		ACL:                 w.acl,
+		ExpectedBucketOwner: w.bucketOwner(),
+		RequestPayer:        w.requestPayer,
+		Key:                 aws.String(key),
+		Body:                bytes.NewReader(data),
+	}, s3Options(ctx)...)
+	if err != nil {
+		return fmt.Errorf("put manifest %s: %w", key, err)
+	}
+	return nil
+}
+
+// acquireManifestLease creates the lease object and returns its ETag, or ""
+// if another instance holds the lease. An abandoned lease is deleted so that
+// the next attempt can take it.
+func (w *S3WAL) acquireManifestLease(ctx context.Context) (string, error) {
+	key := w.manifestLeaseKey()
+	out, err := w.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:              aws.String(w.bucketName),
+		ACL:                 w.acl,
+		ExpectedBucketOwner: w.bucketOwner(),
+		RequestPayer:        w.requestPayer,
+		Key:                 aws.String(key),
+		Body:                bytes.NewReader(nil),
+		IfNoneMatch:         aws.String("*"),
+	}, s3Options(ctx)...)
+	if err == nil {
+		return aws.ToString(out.ETag), nil
+	}
+	if !isPreconditionFailed(err) {
+		return "", fmt.Errorf("put manifest lease %s: %w", key, err)
+	}
+
+	head, err := w.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:              aws.String(w.bucketName),
+		ExpectedBucketOwner: w.bucketOwner(),
+		RequestPayer:        w.requestPayer,
+		Key:                 aws.String(key),
+	}, s3Options(ctx)...)
+	if err != nil {
+		if isNotFound(err) {
+			// released in the meantime
+			return "", nil
+		}
+		return "", fmt.Errorf("head manifest lease %s: %w", key, err)
+	}
+	if head.LastModified != nil && time.Since(*head.LastModified) >= w.manifest.lease {
+		w.deleteManifestLease(ctx, aws.ToString(head.ETag))
+	}
+	return "", nil
+}
+
+// deleteManifestLease deletes the lease object if its ETag is still etag, so
+// that a lease another instance took over is left alone. Failures are
+// ignored: the lease expires on its own.
+func (w *S3WAL) deleteManifestLease(ctx context.Context, etag string) {
+	_, _ = w.client.DeleteObject(context.WithoutCancel(ctx), &s3.DeleteObjectInput{
+		Bucket:              aws.String(w.bucketName),
+		ExpectedBucketOwner: w.bucketOwner(),
+		RequestPayer:        w.requestPayer,
+		Key:                 aws.String(w.manifestLeaseKey()),
+		IfMatch:             aws.String(etag),
+	}, s3Options(ctx)...)
+}
+
+// manifestKey is the object holding the manifest. Like checkpoints it never
+// parses as an offset, so scans skip it.
+func (w *S3WAL) manifestKey() string {
+	return w.listPrefix() + "_manifest"
+}
+
+func (w *S3WAL) manifestLeaseKey() string {
+	return w.listPrefix() + "_manifest.lease"
+}
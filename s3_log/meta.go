@@ -0,0 +1,40 @@
+package s3_log
+
+import (
+	"context"
+	"time"
+)
+
+// ObjectMeta is the S3 metadata of the object a record was read from. For a
+// record packed into a segment it describes the segment object.
+type ObjectMeta struct {
+	Key           string
+	ETag          string
+	LastModified  time.Time // when S3 stored the object
+	ContentLength int64     // size of the stored object, not of the record data
+}
+
+// ReadWithMeta is Read that also returns the metadata S3 sent with the
+// record's object, without a separate HeadObject. It always reads from S3,
+// bypassing WithDiskCache, which keeps no metadata. A record still buffered
+// by WithSegmentation has no object yet and is returned with a zero
+// ObjectMeta.
+func (w *S3WAL) ReadWithMeta(ctx context.Context, offset uint64) (_ Record, _ ObjectMeta, err error) {
+	if len(w.observers) > 0 {
+		defer w.observe(OpRead, time.Now(), &err)
+	}
+	if err := w.beginOp(); err != nil {
+		return Record{}, ObjectMeta{}, err
+	}
+	defer w.endOp()
+
+	if w.segment != nil {
+		w.mu.Lock()
+		rec, ok := w.pendingRecord(offset)
+		w.mu.Unlock()
+		if ok {
+			return rec, ObjectMeta{}, nil
+		}
+	}
+	return w.readStoredMeta(ctx, offset, "")
+}
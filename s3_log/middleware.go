@@ -0,0 +1,35 @@
+package s3_log
+
+import "context"
+
+// AppendMiddleware inspects or transforms the data of a record before it is
+// encoded, e.g. to validate a schema or add fields. Returning an error
+// rejects the record and fails the append with it.
+type AppendMiddleware func(ctx context.Context, data []byte) ([]byte, error)
+
+// applyAppendMiddleware runs the WithAppendMiddleware chain over data.
+func (w *S3WAL) applyAppendMiddleware(ctx context.Context, data []byte) ([]byte, error) {
+	for _, mw := range w.appendMiddleware {
+		var err error
+		if data, err = mw(ctx, data); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// applyAppendMiddlewareAll runs the chain over every record, leaving records
+// itself unchanged.
+func (w *S3WAL) applyAppendMiddlewareAll(ctx context.Context, records [][]byte) ([][]byte, error) {
+	if len(w.appendMiddleware) == 0 {
+		return records, nil
+	}
+	out := make([][]byte, len(records))
+	for i, data := range records {
+		var err error
+		if out[i], err = w.applyAppendMiddleware(ctx, data); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,60 @@
+package s3_log
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// exclaim is an AppendMiddleware that shows how often it ran.
+func exclaim(_ context.Context, data []byte) ([]byte, error) {
+	return append(append([]byte(nil), data...), '!'), nil
+}
+
+// AppendCopyOf copies the stored data, which the middleware transformed
+// already, rather than transforming it again.
+func TestAppendCopyOfSkipsMiddleware(t *testing.T) {
+	_, client := newFake(t)
+	w := NewS3WAL(client, "bucket", "wal", WithAppendMiddleware(exclaim))
+	defer w.Close()
+	if _, err := w.Append(bg, []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	offset, err := w.AppendCopyOf(bg, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := w.Read(bg, offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(rec.Data) != "x!" {
+		t.Fatalf("copy = %q, want %q", rec.Data, "x!")
+	}
+}
+
+// ReplayJournal stores journaled records as they were acknowledged, without
+// running the middleware a second time.
+func TestReplayJournalSkipsMiddleware(t *testing.T) {
+	_, client := newFake(t)
+	dir := t.TempDir()
+	// records stay buffered, as if the process crashed before a flush
+	crashed := NewS3WAL(client, "bucket", "wal", WithAppendMiddleware(exclaim),
+		WithSegmentation(100, 1<<20, time.Hour), WithLocalJournal(dir))
+	if _, err := crashed.Append(bg, []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+
+	w := NewS3WAL(client, "bucket", "wal", WithAppendMiddleware(exclaim), WithLocalJournal(dir))
+	defer w.Close()
+	if n, err := w.ReplayJournal(bg); err != nil || n != 1 {
+		t.Fatalf("ReplayJournal = %d, %v, want 1 record", n, err)
+	}
+	rec, err := w.Read(bg, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(rec.Data) != "x!" {
+		t.Fatalf("replayed record = %q, want %q", rec.Data, "x!")
+	}
+}
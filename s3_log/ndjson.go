@@ -0,0 +1,77 @@
+package s3_log
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+)
+
+// defaultMaxLineSize is the longest line AppendNDJSON accepts by default.
+const defaultMaxLineSize = 1 << 20
+
+// NDJSONOption configures AppendNDJSON.
+type NDJSONOption func(*ndjsonConfig)
+
+type ndjsonConfig struct {
+	maxLineSize int
+}
+
+// WithMaxLineSize sets the longest line AppendNDJSON accepts, 1 MiB by
+// default. A longer line fails the load.
+func WithMaxLineSize(n int) NDJSONOption {
+	if n <= 0 {
+		panic(fmt.Sprintf("s3_log: invalid max line size %d", n))
+	}
+	return func(c *ndjsonConfig) {
+		c.maxLineSize = n
+	}
+}
+
+// AppendNDJSON appends every line read from r as a record, e.g. to load a
+// file of newline-delimited JSON, and returns the offset of the last record
+// in the WAL afterwards. Lines are not parsed; their line ending is dropped
+// and blank lines are skipped. They are appended in batches through
+// BatchAppend, like ImportFrom; on error the records appended so far are
+// kept.
+func (w *S3WAL) AppendNDJSON(ctx context.Context, r io.Reader, opts ...NDJSONOption) (uint64, error) {
+	cfg := ndjsonConfig{maxLineSize: defaultMaxLineSize}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	batch := make([][]byte, 0, importBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		_, err := w.BatchAppend(ctx, batch, FailFast)
+		batch = batch[:0]
+		return err
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, min(cfg.maxLineSize, 64*1024)), cfg.maxLineSize)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		// the scanner reuses its buffer
+		batch = append(batch, append([]byte(nil), scanner.Bytes()...))
+		if len(batch) == importBatchSize {
+			if err := flush(); err != nil {
+				return w.Length(), err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return w.Length(), fmt.Errorf("read line %d: %w", line+1, err)
+	}
+	if err := flush(); err != nil {
+		return w.Length(), err
+	}
+	return w.Length(), nil
+}
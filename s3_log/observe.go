@@ -0,0 +1,30 @@
+package s3_log
+
+import "time"
+
+// Op names an operation reported to WithObserver.
+type Op string
+
+const (
+	OpAppend   Op = "append"   // Append and AppendWithExpiry
+	OpRead     Op = "read"     // Read
+	OpTruncate Op = "truncate" // Truncate and TruncateWithStats
+
+	// maintenance runs of StartMaintenance
+	OpRetention Op = "retention" // TruncateBeforeTime
+	OpCoalesce  Op = "coalesce"  // Coalesce
+)
+
+// Observer is called after each operation with how long it took and the
+// error it returned, if any. It runs on the caller's goroutine, so it must be
+// fast and must not call back into the WAL.
+type Observer func(op Op, elapsed time.Duration, err error)
+
+// observe reports an operation started at start to the observers. It is
+// deferred with a pointer to the operation's error result.
+func (w *S3WAL) observe(op Op, start time.Time, err *error) {
+	elapsed := time.Since(start)
+	for _, fn := range w.observers {
+		fn(op, elapsed, *err)
+	}
+}
@@ -0,0 +1,673 @@
+package s3_log
+
+import (
+	"crypto/cipher"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"golang.org/x/sync/semaphore"
+)
+
+// Option configures optional S3WAL behaviour. Options are applied by NewS3WAL.
+type Option func(*S3WAL)
+
+// WithSegmentation packs appended records into segment objects instead of
+// storing one object per record. A segment is flushed once it holds
+// maxRecords records or maxBytes bytes of record data, and every
+// flushInterval while records are buffered. A zero threshold is ignored.
+//
+// Buffered records are readable through the same S3WAL but are not durable
+// until they are flushed; call Flush or Close before shutting down.
+func WithSegmentation(maxRecords, maxBytes int, flushInterval time.Duration) Option {
+	return func(w *S3WAL) {
+		w.segment = &segmentConfig{
+			maxRecords:    maxRecords,
+			maxBytes:      maxBytes,
+			flushInterval: flushInterval,
+		}
+	}
+}
+
+// WithKeySeparator replaces the "/" between the prefix and the offset in object
+// keys, e.g. "-" for a flat layout without folder semantics: <prefix><sep><offset>.
+// Every key of a WAL shares <prefix><sep> and offsets are fixed width, so key
+// order follows offset order for any separator. Empty separators and ones
+// containing digits are rejected because they blur where the offset starts.
+func WithKeySeparator(sep string) Option {
+	if sep == "" || strings.ContainsAny(sep, "0123456789") {
+		panic(fmt.Sprintf("s3_log: invalid key separator %q", sep))
+	}
+	return func(w *S3WAL) {
+		w.separator = sep
+	}
+}
+
+// WithHashPrefix inserts a directory of the first hexChars hex digits of the
+// SHA-256 of the offset between the prefix and the offset, e.g.
+// <prefix>/3f/<offset>, so writes spread evenly over the key space instead of
+// hitting one hot key range. hexChars must be between 1 and 16.
+//
+// Keys then no longer sort by offset. Recover and Truncate inspect every key
+// anyway, but lookups that otherwise rely on key order (finding the segment of
+// an offset, the next record after a gap in Follow, ExportTo) list the whole
+// WAL instead, and ListPage pages come back in key rather than offset order.
+func WithHashPrefix(hexChars int) Option {
+	if hexChars < 1 || hexChars > 16 {
+		panic(fmt.Sprintf("s3_log: invalid hash prefix length %d", hexChars))
+	}
+	return func(w *S3WAL) {
+		w.hashChars = hexChars
+	}
+}
+
+// WithDatePartitioning stores every record under a directory of the UTC day
+// it was written, <prefix>/yyyy/mm/dd/<offset>, so that tools such as Athena
+// or Glue can prune by date, while offsets stay global. Records carry no
+// timestamp of their own, so the day is the write time; a day never goes
+// back, even if the clock does, which keeps keys sorted by offset and listings
+// such as Recover and Truncate unchanged. The partition separator is always
+// "/", and it cannot be combined with WithHashPrefix or WithDelimiterScoping.
+//
+// The partition of an offset is not derivable from it. The S3WAL keeps a
+// small index of the first offset of each day, filled by its own writes and,
+// when a key is not where the index says, by listing the first key of every
+// partition, one request per day. Reads of records this S3WAL wrote, or
+// written after a reload, cost what they do without partitions; a Read of an
+// offset that does not exist, or of one written by another writer on a day
+// the index has not seen, reloads it first.
+func WithDatePartitioning() Option {
+	return func(w *S3WAL) {
+		w.dates = &datePartitions{}
+	}
+}
+
+// WithMinRetainedRecords makes Truncate refuse, with ErrRetentionViolation, to
+// delete records if fewer than n would be left, e.g. to guard against an
+// accidental truncate 0. ForceTruncate bypasses the guard.
+func WithMinRetainedRecords(n int) Option {
+	if n < 0 {
+		panic(fmt.Sprintf("s3_log: invalid minimum retained records %d", n))
+	}
+	return func(w *S3WAL) {
+		w.minRetained = n
+	}
+}
+
+// WithDiskCache keeps the bodies of records read from S3 as files in dir, up
+// to maxBytes in total with the least recently read evicted first, and serves
+// later Reads of the same offsets from there after validating them again. The
+// cache survives restarts, so dir must be dedicated to this WAL; entries are
+// dropped by Truncate and AppendAt but not by changes made by other writers.
+// Errors preparing dir are reported by Validate.
+func WithDiskCache(dir string, maxBytes int64) Option {
+	if dir == "" || maxBytes <= 0 {
+		panic(fmt.Sprintf("s3_log: invalid disk cache %q with %d bytes", dir, maxBytes))
+	}
+	return func(w *S3WAL) {
+		w.diskCache = newDiskCache(dir, maxBytes)
+	}
+}
+
+// WithLastWriteCache keeps the record Append stored last in memory, so that
+// a Read of its offset right after the Append, as in an append-then-confirm
+// flow, needs no GetObject. Truncate, Overwrite, AppendAt and the other
+// rewrites through this S3WAL drop it; changes made by other writers do not.
+// Records appended with an expiry are not kept.
+func WithLastWriteCache() Option {
+	return func(w *S3WAL) {
+		w.lastWriteCache = true
+	}
+}
+
+// WithLocalJournal durably writes every appended record to a journal file in
+// dir before it is acknowledged, and drops it from there once it reached S3.
+// After a crash ReplayJournal stores the records that never made it, which
+// closes the gap WithSegmentation leaves for buffered records; in the default
+// mode it only covers an Append in flight. Each Append pays for an fsync. dir
+// must be dedicated to this WAL. Errors preparing dir are reported by Validate.
+func WithLocalJournal(dir string) Option {
+	if dir == "" {
+		panic("s3_log: invalid journal dir \"\"")
+	}
+	return func(w *S3WAL) {
+		w.journal = newLocalJournal(dir)
+	}
+}
+
+// WithKeyTransform replaces how offsets are written into object keys, after
+// the prefix and any WithHashPrefix directory, e.g. to put records under date
+// partitions a data lake expects. format must give distinct names that sort
+// in offset order, which listings and segment lookups rely on, and parse must
+// return the offset back and reject any other key; set both together with
+// WithKeyParse. Validate spot-checks both. Segment keys append "-" and the
+// 20-digit first offset to the name of their last one. Names holding "/" do
+// not work with WithDelimiterScoping.
+func WithKeyTransform(format func(offset uint64) string) Option {
+	if format == nil {
+		panic("s3_log: invalid nil key transform")
+	}
+	return func(w *S3WAL) {
+		w.keyFormat = format
+	}
+}
+
+// WithKeyParse is the inverse of WithKeyTransform, which it must be set with.
+func WithKeyParse(parse func(name string) (uint64, error)) Option {
+	if parse == nil {
+		panic("s3_log: invalid nil key parse")
+	}
+	return func(w *S3WAL) {
+		w.keyParse = parse
+	}
+}
+
+// WithKeyFormat writes offsets into object keys as f describes, e.g.
+// KeyFormat{Radix: 16, Uppercase: true} for 16 uppercase hex digits, and sets
+// its Separator like WithKeySeparator. It replaces WithKeyTransform and
+// WithKeyParse, which it is built on, and panics on a format whose names would
+// not all have the same width and sort in offset order.
+func WithKeyFormat(f KeyFormat) Option {
+	f, err := f.normalize()
+	if err != nil {
+		panic(fmt.Sprintf("s3_log: invalid key format: %v", err))
+	}
+	return func(w *S3WAL) {
+		if f.Separator != "" {
+			w.separator = f.Separator
+		}
+		w.keyFormat = f.format
+		w.keyParse = f.parse
+	}
+}
+
+// WithRootPrefix allows an empty prefix and then stores records at the bucket
+// root, with keys like 00000000000000000001 instead of /00000000000000000001.
+// Such a WAL should own its bucket: every listing scans all of it, and keys
+// of other objects are only skipped if they do not parse as offsets. A
+// non-empty prefix is not affected.
+func WithRootPrefix() Option {
+	return func(w *S3WAL) {
+		w.rootPrefix = true
+	}
+}
+
+// WithDelimiterScoping lists with Delimiter "/" so that S3 returns only the
+// direct children of the list prefix, and rejects keys in deeper
+// sub-prefixes. A WAL nested below this one, such as prefix "log/archive"
+// under "log", is then neither scanned by Recover, Truncate and the other
+// listings nor mistaken for records. It cannot be combined with
+// WithHashPrefix under the default "/" separator, whose hash directories are
+// sub-prefixes; Validate reports that.
+func WithDelimiterScoping() Option {
+	return func(w *S3WAL) {
+		w.delimited = true
+	}
+}
+
+// WithUnknownKeyHandler calls fn with every key under the prefix that
+// Recover or Truncate cannot parse as a record or segment, such as objects
+// written by other tools, which are otherwise skipped silently. The WAL's own
+// objects, like replication checkpoints, are not reported. fn may be called
+// from several goroutines at once.
+func WithUnknownKeyHandler(fn func(key string)) Option {
+	return func(w *S3WAL) {
+		w.unknownKeyHandler = fn
+	}
+}
+
+// WithStrictKeys makes Recover and Truncate fail with ErrUnknownKey on the
+// first key under the prefix that is not a WAL object, after passing it to
+// any WithUnknownKeyHandler. A Truncate failing this way may already have
+// deleted records of earlier listing pages.
+func WithStrictKeys() Option {
+	return func(w *S3WAL) {
+		w.strictKeys = true
+	}
+}
+
+// WithTransferAcceleration sends requests to the S3 Transfer Acceleration
+// endpoint, which routes them through the nearest CloudFront edge and speeds
+// up writers far from the bucket's region. Acceleration must be enabled on
+// the bucket, and its name must be DNS-compatible without dots. It configures
+// the client built by NewS3WALFromConfig; with NewS3WAL, set UseAccelerate on
+// the client instead, Validate reports the mismatch.
+func WithTransferAcceleration() Option {
+	return func(w *S3WAL) {
+		w.accelerate = true
+	}
+}
+
+// WithDualStack sends requests to the dual-stack endpoint, reachable over
+// IPv4 and IPv6. Like WithTransferAcceleration it applies to
+// NewS3WALFromConfig only, and the two can be combined.
+func WithDualStack() Option {
+	return func(w *S3WAL) {
+		w.dualStack = true
+	}
+}
+
+// WithForcePathStyle sends every request path-style, to
+// https://<endpoint>/<bucket>/<key>, instead of virtual-hosted style with the
+// bucket in the host name, for S3-compatible stores such as older Ceph RGW
+// gateways that only handle path-style requests. It is also the way to reach
+// a bucket whose name contains dots over HTTPS, since such a host name does
+// not match the wildcard certificate of the endpoint. Like
+// WithTransferAcceleration, which cannot be combined with it, it applies to
+// NewS3WALFromConfig only; with NewS3WAL set UsePathStyle on the client.
+func WithForcePathStyle() Option {
+	return func(w *S3WAL) {
+		w.pathStyle = true
+	}
+}
+
+// WithS3NativeChecksum has S3 compute and store a checksum of every object
+// written (e.g. types.ChecksumAlgorithmCrc32c) and reads request it back so the
+// SDK can verify the downloaded bytes; they are checked again once read, and
+// a mismatch fails with an error wrapping ErrS3ChecksumMismatch. It can be
+// combined with or replace the in-body SHA-256, see WithoutBodyChecksum.
+func WithS3NativeChecksum(algo types.ChecksumAlgorithm) Option {
+	return func(w *S3WAL) {
+		w.checksumAlgorithm = algo
+	}
+}
+
+// WithACL applies a canned ACL to every object the WAL writes. Use
+// types.ObjectCannedACLBucketOwnerFullControl when writing to a bucket of
+// another account whose Object Ownership setting still honours ACLs, so that
+// its owner can read the records. Buckets with BucketOwnerEnforced ownership,
+// the default for new buckets, already own every object and reject requests
+// carrying an ACL other than bucket-owner-full-control; use
+// WithExpectedBucketOwner there instead.
+func WithACL(acl types.ObjectCannedACL) Option {
+	return func(w *S3WAL) {
+		w.acl = acl
+	}
+}
+
+// WithExpectedBucketOwner makes every request fail unless the bucket belongs
+// to accountID, guarding cross-account writes against a bucket that changed
+// hands or a misspelt bucket name. It works with any Object Ownership
+// setting, and is the choice for BucketOwnerEnforced buckets, where the owner
+// reads every object without ACLs.
+func WithExpectedBucketOwner(accountID string) Option {
+	return func(w *S3WAL) {
+		w.expectedOwner = accountID
+	}
+}
+
+// WithRequestPayer sets RequestPayer to requester on every object request,
+// so that a WAL in a Requester Pays bucket can be used from another account,
+// which is billed for the requests and transfer. Without it such buckets
+// deny every request but HeadBucket.
+func WithRequestPayer() Option {
+	return func(w *S3WAL) {
+		w.requestPayer = types.RequestPayerRequester
+	}
+}
+
+// WithoutBodyChecksum stores records as [8-byte offset][data] without the
+// trailing SHA-256, usually together with WithS3NativeChecksum which then
+// carries integrity on its own. Readers must be configured the same way as
+// the writer, as the two layouts cannot be told apart.
+func WithoutBodyChecksum() Option {
+	return func(w *S3WAL) {
+		w.codec = plainCodec{}
+	}
+}
+
+// WithChecksumPlacement selects whether the in-body SHA-256 is written after
+// the data (ChecksumTrailer, the default) or in a header before it
+// (ChecksumHeader), which lets a streaming reader verify as it goes. The
+// placement is recorded in each body, so reads handle both. Like
+// WithRecordCodec, it overrides WithoutBodyChecksum, whichever comes last.
+func WithChecksumPlacement(placement ChecksumPlacement) Option {
+	if placement != ChecksumTrailer && placement != ChecksumHeader {
+		panic(fmt.Sprintf("s3_log: invalid checksum placement %d", placement))
+	}
+	return func(w *S3WAL) {
+		w.codec = checksumCodec{placement: placement}
+	}
+}
+
+// WithZstdCompression compresses record data with zstd whenever that makes it
+// smaller, trying records of at least 32 bytes unless WithCompressionPredicate
+// says otherwise. Compression is recorded per record, so logs can mix
+// compressed and uncompressed records and readers need no option to read
+// them. It applies to the default codec only, not to WithoutBodyChecksum or
+// WithRecordCodec.
+func WithZstdCompression() Option {
+	return func(w *S3WAL) {
+		if w.zstd != nil {
+			return
+		}
+		z, err := newZstdCompression(0, nil)
+		if err != nil {
+			panic(fmt.Sprintf("s3_log: %v", err))
+		}
+		w.zstd = z
+	}
+}
+
+// WithCompressionPredicate makes WithZstdCompression try to compress only the
+// records fn returns true for, e.g. to skip already compressed images and
+// save the CPU. Whether a record was compressed is stored with it, so readers
+// need no option. By default records of at least 32 bytes are tried; a
+// compressed record is kept only if it is smaller either way.
+func WithCompressionPredicate(fn func(data []byte) bool) Option {
+	if fn == nil {
+		panic("s3_log: invalid compression predicate: nil")
+	}
+	return func(w *S3WAL) {
+		w.compressIf = fn
+	}
+}
+
+// WithZstdDictionary is WithZstdCompression with a pre-trained dictionary, see
+// TrainZstdDictionary, which compresses small records of similar structure far
+// better. The dictionary id is stored with every record: readers need the same
+// option to decompress them. Given more than once, the last dictionary is used
+// for writing and all of them for reading, which allows rotating dictionaries.
+func WithZstdDictionary(dict []byte) Option {
+	id, err := zstdDictID(dict)
+	if err != nil {
+		panic(fmt.Sprintf("s3_log: invalid zstd dictionary: %v", err))
+	}
+	return func(w *S3WAL) {
+		z, err := w.zstd.withDict(id, dict)
+		if err != nil {
+			panic(fmt.Sprintf("s3_log: %v", err))
+		}
+		w.zstd = z
+	}
+}
+
+// WithClientEncryption encrypts record data with aead, e.g. from NewAESGCM,
+// before it leaves the process, so S3 only ever stores ciphertext. Every
+// record gets a random nonce stored in its header; the body checksum covers
+// the ciphertext, so corruption is still told apart from a wrong key. The
+// offset and key layout stay in plaintext, so listing, Recover and seeking
+// work without the key. Like WithZstdCompression it applies to the default
+// codec only.
+//
+// Records do not say which key encrypted them. Readers need the key the
+// records were written with and fail with an authentication error otherwise,
+// so rotating keys means copying the log into a WAL with the new key, e.g.
+// with ExportTo and ImportFrom.
+func WithClientEncryption(aead cipher.AEAD) Option {
+	if aead == nil {
+		panic("s3_log: invalid client encryption: nil AEAD")
+	}
+	return func(w *S3WAL) {
+		w.aead = aead
+	}
+}
+
+// WithExternalFormat lets the WAL read objects written under its prefix by
+// something other than this library, e.g. gzip-compressed records from an
+// older pipeline, while migrating. Bodies that do not start like the built-in
+// codec's layouts, whose first byte is 0x00 or 0x01, are passed to decode
+// instead; gzip data, for one, starts with 0x1f. decode returns the record,
+// with a zero Offset standing for the offset in the object's key. Objects
+// still need keys this WAL parses as offsets, and writes use the native
+// format.
+func WithExternalFormat(decode func(body []byte) (Record, error)) Option {
+	if decode == nil {
+		panic("s3_log: invalid nil external format decoder")
+	}
+	return func(w *S3WAL) {
+		w.externalDecode = decode
+	}
+}
+
+// WithRecordCodec replaces the layout of record bodies, e.g. with a fake in
+// tests or a different serialization format. It overrides WithoutBodyChecksum
+// and vice versa, whichever comes last. Like WithoutBodyChecksum, readers must
+// use the same codec as the writer.
+func WithRecordCodec(codec RecordCodec) Option {
+	if codec == nil {
+		panic("s3_log: invalid nil record codec")
+	}
+	return func(w *S3WAL) {
+		w.codec = codec
+	}
+}
+
+// WithRecoverCacheTTL makes Recover reuse the result of a listing for ttl
+// instead of listing again, and collapses concurrent calls into one listing,
+// for services that call Recover from many goroutines. Appends and truncates
+// through this S3WAL invalidate the result; writes by other processes go
+// unnoticed until the TTL expires.
+func WithRecoverCacheTTL(ttl time.Duration) Option {
+	if ttl < 0 {
+		panic(fmt.Sprintf("s3_log: invalid recover cache TTL %s", ttl))
+	}
+	return func(w *S3WAL) {
+		w.recoverTTL = ttl
+	}
+}
+
+// WithManifest makes Recover share its listing between instances, for fleets
+// that start many processes at once. The instance that takes a lease object
+// lists the WAL and writes the result to a manifest object; the others wait
+// for it and start from the manifest, checking that its last record still
+// exists and probing for records appended since. A manifest older than ttl is
+// not used, and a lease older than lease is taken to be left by a crashed
+// instance and broken, so lease should exceed the time a listing takes.
+// Lease age is judged from S3's LastModified against the local clock.
+func WithManifest(ttl, lease time.Duration) Option {
+	if ttl <= 0 || lease <= 0 {
+		panic(fmt.Sprintf("s3_log: invalid manifest TTL %s or lease %s", ttl, lease))
+	}
+	return func(w *S3WAL) {
+		w.manifest = &manifestConfig{ttl: ttl, lease: lease}
+	}
+}
+
+// WithProbeRecover makes Recover and LastRecord find the last offset with
+// HeadObject requests instead of listing, for credentials that may read
+// objects but not list the bucket. Starting from the cached length, offsets
+// are probed at doubling distances up to maxOffset until one is missing, then
+// the boundary is binary searched: about 2*log2(n) requests for n records,
+// against n/1000 list pages. Records must be contiguous from the starting
+// point on, and records stored with AppendGroup or Coalesce are not found, as
+// their objects are not named after each offset.
+func WithProbeRecover(maxOffset uint64) Option {
+	if maxOffset == 0 {
+		panic("s3_log: invalid probe recover max offset 0")
+	}
+	return func(w *S3WAL) {
+		w.probeMax = maxOffset
+	}
+}
+
+// WithAdaptiveConcurrency replaces the fixed number of downloads ReadSet and
+// Iterator prefetching keep in flight with one level shared by all reads of
+// the S3WAL, tuned AIMD-style between min and max: it starts at min, grows by
+// one for every level downloads that complete without error at no more than
+// twice the average latency, and halves when S3 throttles or fails, or
+// latency doubles. ReadConcurrency reports the level. An Iterator still holds
+// at most its WithIteratorPrefetch window ahead.
+func WithAdaptiveConcurrency(min, max int) Option {
+	if min < 1 || max < min {
+		panic(fmt.Sprintf("s3_log: invalid adaptive concurrency %d to %d", min, max))
+	}
+	return func(w *S3WAL) {
+		w.adaptive = newAdaptiveLimiter(min, max)
+	}
+}
+
+// WithOffsetAllocator makes Append, and the methods built on it, store each
+// record at the offset a.Next returns instead of the one after the cached
+// length, e.g. so that several writers each take blocks of offsets from a
+// shared counter. Offsets may then be stored out of order and with gaps; the
+// cached length is the highest offset written. Next is called with the
+// S3WAL's lock held, so concurrent Appends wait for it. BatchAppend,
+// AppendGroup and CompareAndAppend, which choose offsets from the length
+// themselves, return an error.
+func WithOffsetAllocator(a OffsetAllocator) Option {
+	if a == nil {
+		panic("s3_log: invalid nil offset allocator")
+	}
+	return func(w *S3WAL) {
+		w.allocator = a
+	}
+}
+
+// WithAppendMiddleware runs fn over the data of every record appended, by
+// Append, AppendAt, BatchAppend, AppendGroup, CompareAndAppend and the
+// methods built on them, before it is encoded. Middlewares added by repeated
+// uses run in order, each on the output of the previous one; an error aborts
+// the append before anything is stored. Overwrite, Coalesce and other
+// rewrites of stored records do not run it, nor do AppendCopyOf and
+// ReplayJournal, whose data went through it already.
+func WithAppendMiddleware(fn AppendMiddleware) Option {
+	if fn == nil {
+		panic("s3_log: invalid nil append middleware")
+	}
+	return func(w *S3WAL) {
+		w.appendMiddleware = append(w.appendMiddleware, fn)
+	}
+}
+
+// WithFollowInterval sets how often Follow polls for new records. Polling
+// starts at interval and doubles while the log is idle, up to maxInterval.
+func WithFollowInterval(interval, maxInterval time.Duration) Option {
+	return func(w *S3WAL) {
+		if interval <= 0 {
+			interval = defaultFollowInterval
+		}
+		if maxInterval < interval {
+			maxInterval = interval
+		}
+		w.followInterval = interval
+		w.followMaxInterval = maxInterval
+	}
+}
+
+// WithoutInitProbe makes Init skip writing and deleting a probe object, for
+// callers that only hold read permission or want to avoid the two requests.
+func WithoutInitProbe() Option {
+	return func(w *S3WAL) {
+		w.noInitProbe = true
+	}
+}
+
+// WithMaxConcurrentOps caps the S3 requests this S3WAL has in flight at n,
+// across all its methods and goroutines and its background flushes. A request
+// waits for a slot, or for its context to be cancelled, and holds it until
+// its response is read, including the body of a GetObject; retry backoff does
+// not hold one. Unlike a rate limit it does not space requests out. The
+// client passed in is copied, so requests made through it directly are not
+// counted. It needs an *s3.Client, not another S3API.
+func WithMaxConcurrentOps(n int) Option {
+	if n <= 0 {
+		panic(fmt.Sprintf("s3_log: invalid max concurrent ops %d", n))
+	}
+	return func(w *S3WAL) {
+		w.opSlots = semaphore.NewWeighted(int64(n))
+	}
+}
+
+// WithObserver calls fn after every Append, Read and Truncate, e.g. to record
+// latency and error metrics; see the s3walmetrics package for Prometheus.
+// Observers added by repeated uses are all called, in order.
+func WithObserver(fn Observer) Option {
+	if fn == nil {
+		panic("s3_log: invalid nil observer")
+	}
+	return func(w *S3WAL) {
+		w.observers = append(w.observers, fn)
+	}
+}
+
+// WithFailoverClient retries Read and LastRecord against bucketName through
+// secondary, e.g. a replica in another region, when the primary does not
+// answer or answers with a server error. A 404 or other client error is
+// final. An empty bucketName means the primary's name, for example with a
+// Multi-Region Access Point. Writes always go to the primary. The secondary
+// must be a replica kept up to date, e.g. by S3 replication: records not
+// replicated yet are missing from failover reads, so LastRecord may report
+// an older tail than the primary holds.
+func WithFailoverClient(secondary *s3.Client, bucketName string) Option {
+	return func(w *S3WAL) {
+		w.failoverClient = sdkClient(secondary)
+		w.failoverBucket = bucketName
+	}
+}
+
+// WithSQSClient sets the SQS client FollowViaSQS receives S3 event
+// notifications with.
+func WithSQSClient(client *sqs.Client) Option {
+	return func(w *S3WAL) {
+		w.sqsClient = client
+	}
+}
+
+// WithReadAfterWriteVerification makes every write wait until the new object
+// is visible to HeadObject, retrying a few times with backoff, before it is
+// acknowledged. AWS S3 is strongly consistent and does not need this, but some
+// S3-compatible gateways only become consistent after a short delay.
+func WithReadAfterWriteVerification() Option {
+	return func(w *S3WAL) {
+		w.verifyWrites = true
+	}
+}
+
+// WithAmbiguousWriteCheck makes Append check with HeadObject whether its
+// object exists when PutObject fails without a clear answer, e.g. because
+// the connection dropped after S3 stored the object or S3 returned a 5xx. If
+// it exists the append is treated as successful and its offset returned, so
+// the next Append does not reuse and overwrite it. The check cannot tell whose
+// object it found: it assumes this S3WAL is the only writer of its offsets.
+func WithAmbiguousWriteCheck() Option {
+	return func(w *S3WAL) {
+		w.checkAmbiguous = true
+	}
+}
+
+// WithTrustCachedLength makes Truncate delete the keys after its offset up to
+// the cached length, as set by Recover and this S3WAL's writes, directly
+// instead of listing the WAL to find them. It falls back to listing while the
+// length is unknown and in segment mode. LastRecord likewise reads the record
+// at the cached length directly and only lists if it is not there. Use it
+// only if this S3WAL is the sole writer and no objects are written with
+// AppendGroup: records stored by others past the cached length, and group
+// objects, are left behind by Truncate and missed by LastRecord.
+func WithTrustCachedLength() Option {
+	return func(w *S3WAL) {
+		w.trustLength = true
+	}
+}
+
+// WithDeleteRetry sets how often Truncate retries the keys DeleteObjects
+// failed to delete with a retryable error such as SlowDown, and how long it
+// waits before each retry. Only the failed keys are sent again; keys failing
+// with other errors, such as AccessDenied, are reported right away. The
+// default is 3 retries with ExponentialBackoff(100ms, 2s); 0 disables them.
+func WithDeleteRetry(maxRetries int, backoff Backoff) Option {
+	if maxRetries < 0 || backoff == nil {
+		panic(fmt.Sprintf("s3_log: invalid delete retry %d", maxRetries))
+	}
+	return func(w *S3WAL) {
+		w.deleteRetries = maxRetries
+		w.deleteBackoff = backoff
+	}
+}
+
+// WithHashChain makes every record written by Append, AppendWithExpiry,
+// AppendGroup and segment flushes carry the SHA-256 of the previous record's
+// body in its header, chaining the log so that VerifyChain detects records
+// that were changed, removed or inserted. Append keeps the hash of the record
+// it wrote last and only reads the previous record after Recover, Truncate or
+// a write by another method. AppendAt, Overwrite and BatchAppend do not
+// extend the chain, so their records show up as breaks. It needs the default
+// codec.
+func WithHashChain() Option {
+	return func(w *S3WAL) {
+		w.hashChain = true
+	}
+}
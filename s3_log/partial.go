@@ -0,0 +1,144 @@
+package s3_log
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// partialHeaderMax is the longest header in front of the data of a body that
+// ReadPartial can cut short: a formatV1 header with the previous record's
+// hash and the checksum in it.
+const partialHeaderMax = 10 + 32 + 32
+
+// ReadPartial returns the first n bytes of the data of the record at offset,
+// or all of it if it is shorter, downloading little more than those bytes
+// with a ranged GET. Unless the range happens to cover the whole body, the
+// checksum is not downloaded and the data is NOT validated; only the offset
+// in the body is checked. Use Read when integrity matters.
+//
+// Compressed or encrypted records cannot be cut short and fail with an error
+// unless the range covers them whole. A WithRecordCodec codec is not
+// supported. Buffered records are served from memory, and records in
+// segments and WithExternalFormat objects are read whole and then cut.
+func (w *S3WAL) ReadPartial(ctx context.Context, offset uint64, n int) ([]byte, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("s3_log: invalid partial read length %d", n)
+	}
+	prefixOf := func(rec Record) []byte {
+		return rec.Data[:min(n, len(rec.Data))]
+	}
+	if w.segment != nil {
+		w.mu.Lock()
+		rec, ok := w.pendingRecord(offset)
+		w.mu.Unlock()
+		if ok {
+			return prefixOf(rec), nil
+		}
+	}
+	if err := w.beginOp(); err != nil {
+		return nil, err
+	}
+	defer w.endOp()
+
+	_, isChecksum := w.codec.(checksumCodec)
+	_, isPlain := w.codec.(plainCodec)
+	if !isChecksum && !isPlain {
+		return nil, errors.New("s3_log: ReadPartial does not support WithRecordCodec")
+	}
+
+	key := w.getObjectKey(offset)
+	data, complete, err := w.getPrefix(ctx, key, int64(n+partialHeaderMax))
+	if err != nil {
+		if !isNotFound(err) {
+			return nil, err
+		}
+		rec, err := w.readStored(ctx, offset, "")
+		if err != nil {
+			return nil, err
+		}
+		return prefixOf(rec), nil
+	}
+	if complete || (w.externalDecode != nil && !isNativeBody(data)) {
+		if !complete {
+			if data, err = w.getBody(ctx, key); err != nil {
+				return nil, err
+			}
+		}
+		rec, err := w.decodeRecord(key, offset, data)
+		if err != nil {
+			return nil, err
+		}
+		return prefixOf(rec), nil
+	}
+
+	start := 8
+	if len(data) > 0 && data[0] == formatV1 && isChecksum {
+		flags := data[1]
+		if flags&(flagCompressed|flagEncrypted) != 0 {
+			return nil, fmt.Errorf("offset %d is compressed or encrypted and cannot be read partially", offset)
+		}
+		if flags&^knownFlags != 0 {
+			return nil, fmt.Errorf("unsupported record flags %#x", flags)
+		}
+		data, start = data[2:], 8
+		if flags&flagChained != 0 {
+			start += 32
+		}
+		if flags&flagChecksumHeader != 0 {
+			start += 32
+		}
+	}
+	if len(data) < start {
+		return nil, fmt.Errorf("decode record %s: %w", key, ErrRecordTooShort)
+	}
+	if got := binary.BigEndian.Uint64(data[:8]); got != offset {
+		return nil, fmt.Errorf("key %s: %w: expected %d, got %d", key, ErrOffsetMismatch, offset, got)
+	}
+	payload := data[start:]
+	return append([]byte(nil), payload[:min(n, len(payload))]...), nil
+}
+
+// getPrefix downloads up to the first size bytes of an object and reports
+// whether that is the whole object.
+func (w *S3WAL) getPrefix(ctx context.Context, key string, size int64) ([]byte, bool, error) {
+	var data []byte
+	var ranged bool
+	var total int64
+	err := w.withFailover(func(client S3API, bucket string) error {
+		out, err := client.GetObject(ctx, &s3.GetObjectInput{
+			Bucket:              aws.String(bucket),
+			ExpectedBucketOwner: w.bucketOwner(),
+			RequestPayer:        w.requestPayer,
+			Key:                 aws.String(key),
+			Range:               aws.String(fmt.Sprintf("bytes=0-%d", size-1)),
+		}, s3Options(ctx)...)
+		if err != nil {
+			return fmt.Errorf("get object %s: %w", key, err)
+		}
+		defer out.Body.Close()
+
+		data, err = io.ReadAll(out.Body)
+		if err != nil {
+			return fmt.Errorf("read object %s body: %w", key, err)
+		}
+		// "bytes 0-41/1234", or no range at all if the whole body was sent
+		if _, t, ok := strings.Cut(aws.ToString(out.ContentRange), "/"); ok {
+			ranged = true
+			total, _ = strconv.ParseInt(t, 10, 64)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	complete := !ranged || int64(len(data)) < size || total == int64(len(data))
+	return data, complete, nil
+}
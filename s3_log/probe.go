@@ -0,0 +1,92 @@
+package s3_log
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+)
+
+// probeLength finds the highest stored offset without listing, for
+// WithProbeRecover. From the cached length, or 1 if it is unknown or gone, it
+// probes offsets at doubling distances until one is missing and then binary
+// searches between the last hit and that miss. The caller holds w.mu.
+func (w *S3WAL) probeLength(ctx context.Context) (uint64, error) {
+	lo := min(w.length, w.probeMax) // highest offset known to exist, 0 for none
+	if lo > 0 {
+		ok, err := w.probeOffset(ctx, lo)
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			// truncated by another writer, start over
+			lo = 0
+		}
+	}
+
+	var hi uint64 // lowest offset known to be missing
+	for step := uint64(1); hi == 0; {
+		if lo == w.probeMax {
+			return lo, nil
+		}
+		next := lo + min(step, w.probeMax-lo)
+		ok, err := w.probeOffset(ctx, next)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			lo = next
+			if step < 1<<63 {
+				step *= 2
+			}
+		} else {
+			hi = next
+		}
+	}
+	for hi-lo > 1 {
+		mid := lo + (hi-lo)/2
+		ok, err := w.probeOffset(ctx, mid)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return lo, nil
+}
+
+// probeOffset reports whether the record at offset has its own object. S3
+// answers a HeadObject for a missing key with 403 instead of 404 when the
+// caller may not list the bucket, so both count as missing.
+func (w *S3WAL) probeOffset(ctx context.Context, offset uint64) (bool, error) {
+	key := w.getObjectKey(offset)
+	_, err := w.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:              aws.String(w.bucketName),
+		ExpectedBucketOwner: w.bucketOwner(),
+		RequestPayer:        w.requestPayer,
+		Key:                 aws.String(key),
+	}, s3Options(ctx)...)
+	if err == nil {
+		return true, nil
+	}
+	if isNotFound(err) || isAccessDenied(err) {
+		return false, nil
+	}
+	return false, fmt.Errorf("head object %s: %w", key, err)
+}
+
+// isAccessDenied reports whether err is S3 refusing the request with 403.
+func isAccessDenied(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	// a HEAD response has no body, so its error code is the status text
+	return apiErr.ErrorCode() == "AccessDenied" || apiErr.ErrorCode() == "Forbidden"
+}
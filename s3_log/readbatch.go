@@ -0,0 +1,67 @@
+package s3_log
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// ReadBatch reads the stored records from offset from (inclusive) onwards,
+// in offset order, until maxCount records or maxBytes bytes of record data
+// have been collected, and returns them with the offset to resume from. The
+// first record is always returned, even if it alone exceeds maxBytes, so
+// that a loop over ReadBatch makes progress. At the end of the WAL fewer
+// records, possibly none, are returned and next is one past the last of
+// them, or from if there are none; buffered records are not included.
+//
+//	for from := uint64(1); ; {
+//		records, next, err := w.ReadBatch(ctx, from, 100, 1<<20)
+//		if err != nil { ... }
+//		if len(records) == 0 {
+//			break
+//		}
+//		process(records)
+//		from = next
+//	}
+func (w *S3WAL) ReadBatch(ctx context.Context, from uint64, maxCount int, maxBytes int64) (records []Record, next uint64, err error) {
+	if maxCount <= 0 {
+		return nil, from, fmt.Errorf("s3_log: invalid batch count %d", maxCount)
+	}
+	if maxBytes <= 0 {
+		return nil, from, fmt.Errorf("s3_log: invalid batch size %d", maxBytes)
+	}
+
+	next = from
+	var size int64
+	err = w.walkObjects(ctx, func(obj types.Object, first, last uint64) error {
+		if last < from {
+			return nil
+		}
+		objRecords, err := w.readObject(ctx, aws.ToString(obj.Key), first, last)
+		if err != nil {
+			return err
+		}
+		for _, rec := range objRecords {
+			if rec.Offset < from {
+				continue
+			}
+			if len(records) > 0 && size+int64(len(rec.Data)) > maxBytes {
+				return errStopWalk
+			}
+			records = append(records, rec)
+			size += int64(len(rec.Data))
+			next = rec.Offset + 1
+			if len(records) == maxCount {
+				return errStopWalk
+			}
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errStopWalk) {
+		return nil, from, err
+	}
+	return records, next, nil
+}
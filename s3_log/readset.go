@@ -0,0 +1,68 @@
+package s3_log
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+// defaultReadSetConcurrency bounds the reads ReadSet keeps in flight, unless
+// WithAdaptiveConcurrency is set.
+const defaultReadSetConcurrency = 8
+
+// ReadSet reads the records at offsets and returns them in the order of
+// offsets. Each distinct offset is read once, in ascending order, with up to
+// defaultReadSetConcurrency reads, or the WithAdaptiveConcurrency level, in
+// flight; a repeated offset yields the same record at every position. The
+// first failed read, e.g. of a missing offset, cancels the others and is
+// returned.
+func (w *S3WAL) ReadSet(ctx context.Context, offsets []uint64) ([]Record, error) {
+	unique := slices.Clone(offsets)
+	slices.Sort(unique)
+	unique = slices.Compact(unique)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	read := make([]Record, len(unique))
+	var firstErr error
+	var failOnce sync.Once
+	slots := w.readLimiter(defaultReadSetConcurrency)
+	var wg sync.WaitGroup
+	for i, offset := range unique {
+		if ctx.Err() != nil || slots.acquire(ctx) != nil {
+			break
+		}
+		wg.Add(1)
+		go func(i int, offset uint64) {
+			defer wg.Done()
+			start := time.Now()
+			rec, err := w.Read(ctx, offset)
+			slots.release(start, err)
+			if err != nil {
+				failOnce.Do(func() {
+					firstErr = err
+					cancel()
+				})
+				return
+			}
+			read[i] = rec
+		}(i, offset)
+	}
+	wg.Wait()
+	if firstErr == nil {
+		// cancelled by the caller
+		firstErr = ctx.Err()
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	records := make([]Record, len(offsets))
+	for i, offset := range offsets {
+		j, _ := slices.BinarySearch(unique, offset)
+		records[i] = read[j]
+	}
+	return records, nil
+}
@@ -0,0 +1,63 @@
+package s3_log
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// RefreshTail reconciles the cached length with S3 without listing, as a
+// cheap periodic check under WithTrustCachedLength when another process
+// occasionally appends too. It confirms with a HeadObject that the record at
+// the cached length still exists, then probes the offsets after it one by
+// one until one is missing, and returns the corrected length: one request
+// per record others appended, plus two. If the record at the cached length is
+// gone, the WAL was truncated behind this S3WAL's back and it falls back to
+// Recover's listing. Records stored with AppendGroup are not found by the
+// probes, and it cannot be used with WithSegmentation or
+// WithDatePartitioning, whose keys are not known in advance.
+func (w *S3WAL) RefreshTail(ctx context.Context) (uint64, error) {
+	if err := w.beginOp(); err != nil {
+		return 0, err
+	}
+	defer w.endOp()
+	if w.segment != nil || w.dates != nil {
+		return 0, errors.New("s3_log: RefreshTail cannot be used with WithSegmentation or WithDatePartitioning")
+	}
+
+	w.mu.Lock()
+	length, ok, err := w.refreshTailLocked(ctx)
+	w.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return w.recover(ctx, nil)
+	}
+	return length, nil
+}
+
+// refreshTailLocked is RefreshTail's probing. It reports false if the record
+// at the cached length is missing. The caller holds w.mu.
+func (w *S3WAL) refreshTailLocked(ctx context.Context) (uint64, bool, error) {
+	length := w.length
+	if length > 0 {
+		ok, err := w.probeOffset(ctx, length)
+		if err != nil || !ok {
+			return 0, false, err
+		}
+	}
+	for {
+		ok, err := w.probeOffset(ctx, length+1)
+		if err != nil {
+			return 0, false, err
+		}
+		if !ok {
+			break
+		}
+		length++
+	}
+	w.length = length
+	w.recoveredAt = time.Now()
+	return length, true, nil
+}
@@ -0,0 +1,212 @@
+package s3_log
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// ReplicateOption configures Replicate.
+type ReplicateOption func(*replicateConfig)
+
+type replicateConfig struct {
+	preserveOffsets    bool
+	checkpointEvery    int
+	checkpointInterval time.Duration
+}
+
+// PreserveOffsets stores every record at its source offset with
+// AppendAtIfAbsent instead of appending it, so the destination mirrors the
+// source's offset space, gaps included.
+func PreserveOffsets() ReplicateOption {
+	return func(c *replicateConfig) {
+		c.preserveOffsets = true
+	}
+}
+
+// CheckpointEvery makes Replicate save its position after n records or once
+// interval has passed since the last checkpoint, whichever comes first.
+// The defaults are 100 records and 10 seconds.
+func CheckpointEvery(n int, interval time.Duration) ReplicateOption {
+	if n <= 0 || interval <= 0 {
+		panic(fmt.Sprintf("s3_log: invalid checkpoint frequency %d/%s", n, interval))
+	}
+	return func(c *replicateConfig) {
+		c.checkpointEvery = n
+		c.checkpointInterval = interval
+	}
+}
+
+// replicationCheckpoint is the last source record replicated and the
+// destination offset it was stored at.
+type replicationCheckpoint struct {
+	src, dst uint64
+}
+
+// Replicate follows src and writes every record to dst until ctx is cancelled
+// or an error occurs, checkpointing its position in an object next to dst's
+// records so that a later call resumes where this one stopped.
+//
+// With fromOffset 0 replication resumes after the checkpoint, or starts at
+// the beginning of src without one. Records dst already holds are not
+// written twice: with PreserveOffsets they are detected by offset, otherwise
+// dst is assumed to be written by Replicate only and the records it gained
+// since the checkpoint are skipped in src. A non-zero fromOffset starts there
+// unconditionally.
+//
+// In segment mode dst is flushed before every checkpoint. A final checkpoint
+// is saved on return, also when ctx is cancelled.
+func Replicate(ctx context.Context, src, dst *S3WAL, fromOffset uint64, opts ...ReplicateOption) error {
+	cfg := replicateConfig{
+		checkpointEvery:    100,
+		checkpointInterval: 10 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	dstLength, err := dst.Recover(ctx)
+	if err != nil {
+		return fmt.Errorf("recover destination: %w", err)
+	}
+	ck, err := dst.loadCheckpoint(ctx, src)
+	if err != nil {
+		return err
+	}
+
+	from := fromOffset
+	var skip uint64
+	if from == 0 {
+		from = ck.src + 1
+		if !cfg.preserveOffsets && dstLength > ck.dst {
+			skip = dstLength - ck.dst
+		}
+	}
+
+	followCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	records, errs := src.Follow(followCtx, from)
+
+	saved := ck
+	lastSave := time.Now()
+	save := func(ctx context.Context) error {
+		if ck == saved {
+			return nil
+		}
+		if err := dst.Flush(ctx); err != nil {
+			return fmt.Errorf("flush destination: %w", err)
+		}
+		if err := dst.saveCheckpoint(ctx, src, ck); err != nil {
+			return err
+		}
+		saved = ck
+		lastSave = time.Now()
+		return nil
+	}
+
+	var pending int
+	var replErr error
+	for rec := range records {
+		if skip > 0 {
+			skip--
+			ck = replicationCheckpoint{src: rec.Offset, dst: ck.dst + 1}
+			continue
+		}
+		if cfg.preserveOffsets {
+			err := dst.AppendAtIfAbsent(ctx, rec.Offset, rec.Data)
+			if err != nil && !errors.Is(err, ErrOffsetExists) {
+				replErr = fmt.Errorf("replicate offset %d: %w", rec.Offset, err)
+				break
+			}
+			ck = replicationCheckpoint{src: rec.Offset, dst: rec.Offset}
+		} else {
+			offset, err := dst.Append(ctx, rec.Data)
+			if err != nil {
+				replErr = fmt.Errorf("replicate offset %d: %w", rec.Offset, err)
+				break
+			}
+			ck = replicationCheckpoint{src: rec.Offset, dst: offset}
+		}
+
+		pending++
+		if pending >= cfg.checkpointEvery || time.Since(lastSave) >= cfg.checkpointInterval {
+			if err := save(ctx); err != nil {
+				replErr = err
+				break
+			}
+			pending = 0
+		}
+	}
+	cancel()
+	if replErr == nil {
+		replErr = <-errs
+	}
+
+	// record progress even when ctx is done
+	if err := save(context.WithoutCancel(ctx)); err != nil && replErr == nil {
+		replErr = err
+	}
+	if replErr == nil {
+		replErr = ctx.Err()
+	}
+	return replErr
+}
+
+// checkpointKey is the object holding the replication checkpoint of src in
+// w. It lives under w's list prefix but never parses as an offset, so scans
+// skip it.
+func (w *S3WAL) checkpointKey(src *S3WAL) string {
+	return w.listPrefix() + "_replication/" + url.PathEscape(src.bucketName+"/"+src.prefix)
+}
+
+// loadCheckpoint reads the replication checkpoint of src; it is zero if none was saved.
+func (w *S3WAL) loadCheckpoint(ctx context.Context, src *S3WAL) (replicationCheckpoint, error) {
+	key := w.checkpointKey(src)
+	out, err := w.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket:              aws.String(w.bucketName),
+		ExpectedBucketOwner: w.bucketOwner(),
+		RequestPayer:        w.requestPayer,
+		Key:                 aws.String(key),
+	}, s3Options(ctx)...)
+	if err != nil {
+		if isNotFound(err) {
+			return replicationCheckpoint{}, nil
+		}
+		return replicationCheckpoint{}, fmt.Errorf("get checkpoint %s: %w", key, err)
+	}
+	defer out.Body.Close()
+
+	data, err := io.ReadAll(out.Body)
+	if err != nil {
+		return replicationCheckpoint{}, fmt.Errorf("read checkpoint %s: %w", key, err)
+	}
+	var ck replicationCheckpoint
+	if _, err := fmt.Sscanf(string(data), "%d %d", &ck.src, &ck.dst); err != nil {
+		return replicationCheckpoint{}, fmt.Errorf("parse checkpoint %s: %w", key, err)
+	}
+	return ck, nil
+}
+
+// saveCheckpoint stores ck as "<src offset> <dst offset>".
+func (w *S3WAL) saveCheckpoint(ctx context.Context, src *S3WAL, ck replicationCheckpoint) error {
+	key := w.checkpointKey(src)
+	_, err := w.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:              aws.String(w.bucketName),
+		ACL:                 w.acl,
+		ExpectedBucketOwner: w.bucketOwner(),
+		RequestPayer:        w.requestPayer,
+		Key:                 aws.String(key),
+		Body:                bytes.NewReader([]byte(fmt.Sprintf("%d %d", ck.src, ck.dst))),
+	}, s3Options(ctx)...)
+	if err != nil {
+		return fmt.Errorf("put checkpoint %s: %w", key, err)
+	}
+	return nil
+}
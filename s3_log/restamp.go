@@ -0,0 +1,113 @@
+package s3_log
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// RestampOffsets repairs a log whose objects were copied out of band, e.g.
+// with "aws s3 cp" to keys for other offsets, so that the offset in each body
+// no longer matches its key and Read fails. It reads every object without
+// the offset check and rewrites the ones that disagree with their key, or
+// hold a segment record that does, with the key's offsets. Bodies must still
+// pass their checksum, and are re-encoded with the WAL's current codec
+// options.
+//
+// Objects that already match are left alone, so it is idempotent, and
+// running it again after a failure resumes where it stopped. Each rewrite is
+// conditional on the object being unchanged since it was read, so a
+// concurrent writer is never overwritten. Buffered records are not touched.
+func (w *S3WAL) RestampOffsets(ctx context.Context) error {
+	if err := w.beginOp(); err != nil {
+		return err
+	}
+	defer w.endOp()
+
+	return w.walkObjects(ctx, func(obj types.Object, first, last uint64) error {
+		key := aws.ToString(obj.Key)
+		data, meta, err := w.getObject(ctx, key, "")
+		if err != nil {
+			return err
+		}
+		body, changed, err := w.restampBody(key, data, first, last)
+		if err != nil || !changed {
+			return err
+		}
+		_, err = w.client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:              aws.String(w.bucketName),
+			ACL:                 w.acl,
+			ExpectedBucketOwner: w.bucketOwner(),
+			RequestPayer:        w.requestPayer,
+			Key:                 aws.String(key),
+			Body:                bytes.NewReader(body),
+			ChecksumAlgorithm:   w.checksumAlgorithm,
+			IfMatch:             aws.String(meta.ETag),
+		}, s3Options(ctx)...)
+		if err != nil {
+			if isPreconditionFailed(err) {
+				return fmt.Errorf("restamp %s: object changed concurrently: %w", key, err)
+			}
+			return fmt.Errorf("restamp %s: %w", key, err)
+		}
+		if w.diskCache != nil {
+			for offset := first; offset <= last; offset++ {
+				w.diskCache.remove(offset)
+			}
+		}
+		w.dropLastWrite(first, last)
+		return nil
+	})
+}
+
+// restampBody returns data, the body of an object covering first..last,
+// re-encoded with those offsets, and whether any record's offset differed.
+func (w *S3WAL) restampBody(key string, data []byte, first, last uint64) ([]byte, bool, error) {
+	if !isSegment(data) {
+		rec, err := w.codec.Unmarshal(data)
+		if err != nil {
+			return nil, false, fmt.Errorf("decode record %s: %w", key, err)
+		}
+		if rec.Offset == first {
+			return nil, false, nil
+		}
+		body, err := w.encodeBody(first, rec.Data)
+		if err != nil {
+			return nil, false, fmt.Errorf("prepare body: %w", err)
+		}
+		return body, true, nil
+	}
+
+	bodies, err := segmentBodies(data)
+	if err != nil {
+		return nil, false, fmt.Errorf("parse segment %s: %w", key, err)
+	}
+	if uint64(len(bodies)) != last-first+1 {
+		return nil, false, fmt.Errorf("segment %s holds %d records, key says %d", key, len(bodies), last-first+1)
+	}
+	records := make([]Record, len(bodies))
+	changed := false
+	for i, b := range bodies {
+		rec, err := w.codec.Unmarshal(b)
+		if err != nil {
+			return nil, false, fmt.Errorf("decode record %s: %w", key, err)
+		}
+		if rec.Offset != first+uint64(i) {
+			rec.Offset = first + uint64(i)
+			changed = true
+		}
+		records[i] = rec
+	}
+	if !changed {
+		return nil, false, nil
+	}
+	body, _, err := w.encodeSegment(records)
+	if err != nil {
+		return nil, false, err
+	}
+	return body, true, nil
+}
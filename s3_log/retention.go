@@ -0,0 +1,114 @@
+package s3_log
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// TruncateBeforeTime deletes the oldest records, those stored before cutoff,
+// e.g. to keep only the last 7 days. Records carry no timestamp of their own,
+// so the LastModified time of their object is used: walking in offset order,
+// objects are deleted up to the first one modified at or after cutoff, which
+// keeps the log a contiguous range of offsets. A segment written by Coalesce
+// counts as old as the newest record packed into it. A record rewritten later,
+// by Overwrite or another segment rewrite, counts as new and stops the walk.
+//
+// If no record is older than cutoff nothing is deleted; if all are, the WAL
+// is left empty and Length is kept. Recover and LastRecord then continue
+// from the trim marker, so offsets are not reused after a restart. Buffered
+// records are not affected. With WithMinRetainedRecords it returns
+// ErrRetentionViolation instead of deleting anything if fewer records would
+// be left. Objects S3 fails to delete are reported as a *DeleteError.
+//
+// Before deleting, the lowest remaining offset is saved for MinValidOffset.
+func (w *S3WAL) TruncateBeforeTime(ctx context.Context, cutoff time.Time) error {
+	if err := w.beginOp(); err != nil {
+		return err
+	}
+	defer w.endOp()
+	return w.truncateBeforeTime(ctx, cutoff, false)
+}
+
+// truncateBeforeTime is TruncateBeforeTime. With keepNewest the newest
+// object is never deleted, however old, so that every way of finding the
+// length, including probing and other processes' Recover, still sees the
+// last offset.
+func (w *S3WAL) truncateBeforeTime(ctx context.Context, cutoff time.Time, keepNewest bool) error {
+	type object struct {
+		key         *string
+		first, last uint64
+	}
+	var old []object
+	var total, deleted uint64
+	kept := false // reached the first object to keep
+	err := w.walkObjects(ctx, func(obj types.Object, first, last uint64) error {
+		total += last - first + 1
+		if kept {
+			return nil
+		}
+		modified := aws.ToTime(obj.LastModified)
+		if !modified.Before(cutoff) && (first != last || aws.ToString(obj.Key) != w.getObjectKey(first)) {
+			// a segment may hold records older than itself
+			var err error
+			if modified, err = w.coalescedAt(ctx, aws.ToString(obj.Key), modified); err != nil {
+				return err
+			}
+		}
+		if modified.Before(cutoff) {
+			old = append(old, object{obj.Key, first, last})
+			deleted += last - first + 1
+		} else {
+			kept = true
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if keepNewest && !kept && len(old) > 0 {
+		deleted -= old[len(old)-1].last - old[len(old)-1].first + 1
+		old = old[:len(old)-1]
+	}
+	if len(old) == 0 {
+		return nil
+	}
+	if w.minRetained > 0 && total-deleted < uint64(w.minRetained) {
+		return fmt.Errorf("%w: truncating records before %s keeps %d of %d records, minimum is %d",
+			ErrRetentionViolation, cutoff.Format(time.RFC3339), total-deleted, total, w.minRetained)
+	}
+
+	if err := w.saveTrimMarker(ctx, old[len(old)-1].last+1); err != nil {
+		return err
+	}
+
+	deleteErr := &DeleteError{}
+	for start := 0; start < len(old); start += 1000 {
+		batch := old[start:min(start+1000, len(old))]
+		keys := make([]types.ObjectIdentifier, len(batch))
+		for i, o := range batch {
+			keys[i] = types.ObjectIdentifier{Key: o.key}
+			w.dropLastWrite(o.first, o.last)
+			if w.diskCache != nil {
+				for offset := o.first; offset <= o.last; offset++ {
+					w.diskCache.remove(offset)
+				}
+			}
+		}
+		err := w.batchDelete(ctx, keys)
+		var de *DeleteError
+		if errors.As(err, &de) {
+			deleteErr.Failed = append(deleteErr.Failed, de.Failed...)
+		} else if err != nil {
+			return err
+		}
+	}
+	if len(deleteErr.Failed) > 0 {
+		return deleteErr
+	}
+	return nil
+}
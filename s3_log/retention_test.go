@@ -0,0 +1,82 @@
+package s3_log
+
+import (
+	"testing"
+	"time"
+)
+
+// After TruncateBeforeTime deletes every record, a restarted WAL must
+// continue after the trimmed offsets instead of reusing them.
+func TestTruncateBeforeTimeAllRecordsKeepsLength(t *testing.T) {
+	_, client := newFake(t)
+	w := NewS3WAL(client, "bucket", "wal")
+	defer w.Close()
+	for i := 0; i < 3; i++ {
+		if _, err := w.Append(bg, []byte("data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.TruncateBeforeTime(bg, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := NewS3WAL(client, "bucket", "wal")
+	defer restarted.Close()
+	length, err := restarted.Recover(bg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if length != 3 {
+		t.Fatalf("Recover = %d, want 3", length)
+	}
+	offset, err := restarted.Append(bg, []byte("next"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset != 4 {
+		t.Fatalf("Append after restart = offset %d, want 4", offset)
+	}
+
+	// LastRecord has no record to return but still learns the length
+	restarted = NewS3WAL(client, "bucket", "wal")
+	defer restarted.Close()
+	if err := restarted.TruncateBeforeTime(bg, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restarted.LastRecord(bg); err == nil {
+		t.Fatal("LastRecord of an empty WAL succeeded")
+	}
+	if offset, err := restarted.Append(bg, []byte("next")); err != nil || offset != 5 {
+		t.Fatalf("Append after LastRecord = %d, %v, want offset 5", offset, err)
+	}
+}
+
+// Records packed by Coalesce keep their age: the new segment object does not
+// delay their retention.
+func TestTruncateBeforeTimeCoalescedRecords(t *testing.T) {
+	f, client := newFake(t)
+	w := NewS3WAL(client, "bucket", "wal")
+	defer w.Close()
+	for i := 0; i < 5; i++ {
+		if _, err := w.Append(bg, []byte("data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for offset := uint64(1); offset <= 4; offset++ {
+		f.setModified(w.getObjectKey(offset), time.Now().Add(-time.Hour))
+	}
+	if err := w.Coalesce(bg, 2); err != nil {
+		t.Fatal(err)
+	}
+	if keys := f.keys(); len(keys) != 3 {
+		t.Fatalf("keys after Coalesce = %q, want two segments and record 5", keys)
+	}
+
+	if err := w.TruncateBeforeTime(bg, time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	keys := f.keys()
+	if len(keys) != 2 || keys[0] != w.getObjectKey(5) {
+		t.Fatalf("keys after retention = %q, want record 5 and the trim marker", keys)
+	}
+}
@@ -0,0 +1,48 @@
+package s3_log
+
+import (
+	"context"
+	"time"
+)
+
+// Backoff returns how long to wait before retry number retry, starting at 1.
+type Backoff func(retry int) time.Duration
+
+// ExponentialBackoff waits base before the first retry and doubles the wait
+// for every further one, up to max.
+func ExponentialBackoff(base, max time.Duration) Backoff {
+	return func(retry int) time.Duration {
+		d := base
+		for i := 1; i < retry && d < max; i++ {
+			d *= 2
+		}
+		return min(d, max)
+	}
+}
+
+// Truncate retries keys DeleteObjects failed to delete with a retryable error
+// this many times by default, see WithDeleteRetry.
+const defaultDeleteRetries = 3
+
+var defaultDeleteBackoff = ExponentialBackoff(100*time.Millisecond, 2*time.Second)
+
+// retryableDeleteCodes are the DeleteObjects per-key error codes worth
+// retrying; any other code, such as AccessDenied, is final.
+var retryableDeleteCodes = map[string]bool{
+	"SlowDown":           true,
+	"InternalError":      true,
+	"ServiceUnavailable": true,
+	"RequestTimeout":     true,
+}
+
+// sleepCtx waits for d or until ctx is done.
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,87 @@
+package s3_log
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// deleteMock is an S3API whose DeleteObjects fails keys with the codes in
+// fail, each code once per key listed, and records the keys of every call.
+type deleteMock struct {
+	S3API // nil, only DeleteObjects is called
+
+	mu    sync.Mutex
+	fail  map[string][]string
+	calls [][]string
+}
+
+func (m *deleteMock) DeleteObjects(_ context.Context, in *s3.DeleteObjectsInput, _ ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := &s3.DeleteObjectsOutput{}
+	var keys []string
+	for _, obj := range in.Delete.Objects {
+		key := aws.ToString(obj.Key)
+		keys = append(keys, key)
+		if codes := m.fail[key]; len(codes) > 0 {
+			m.fail[key] = codes[1:]
+			out.Errors = append(out.Errors, types.Error{Key: obj.Key, Code: aws.String(codes[0])})
+			continue
+		}
+		out.Deleted = append(out.Deleted, types.DeletedObject{Key: obj.Key})
+	}
+	m.calls = append(m.calls, keys)
+	return out, nil
+}
+
+// The delete retry sends only the keys that failed with a retryable code
+// again, and reports the others right away.
+func TestBatchDeleteRetriesOnlyFailedKeys(t *testing.T) {
+	mock := &deleteMock{fail: map[string][]string{
+		"wal/b": {"SlowDown", "InternalError"},
+		"wal/c": {"AccessDenied"},
+	}}
+	w := NewS3WALWithAPI(mock, "bucket", "wal", WithDeleteRetry(3, func(int) time.Duration { return 0 }))
+	defer w.Close()
+
+	var keys []types.ObjectIdentifier
+	for _, k := range []string{"wal/a", "wal/b", "wal/c", "wal/d"} {
+		keys = append(keys, types.ObjectIdentifier{Key: aws.String(k)})
+	}
+	err := w.batchDelete(bg, keys)
+
+	var de *DeleteError
+	if !errors.As(err, &de) || len(de.Failed) != 1 || aws.ToString(de.Failed[0].Object.Key) != "wal/c" || de.Failed[0].Code != "AccessDenied" {
+		t.Fatalf("batchDelete error = %v, want wal/c denied", err)
+	}
+	want := [][]string{{"wal/a", "wal/b", "wal/c", "wal/d"}, {"wal/b"}, {"wal/b"}}
+	if !reflect.DeepEqual(mock.calls, want) {
+		t.Fatalf("DeleteObjects calls = %q, want %q", mock.calls, want)
+	}
+}
+
+// A key still failing once the retries are used up is reported.
+func TestBatchDeleteGivesUp(t *testing.T) {
+	mock := &deleteMock{fail: map[string][]string{
+		"wal/a": {"SlowDown", "SlowDown", "SlowDown"},
+	}}
+	w := NewS3WALWithAPI(mock, "bucket", "wal", WithDeleteRetry(2, func(int) time.Duration { return 0 }))
+	defer w.Close()
+
+	err := w.batchDelete(bg, []types.ObjectIdentifier{{Key: aws.String("wal/a")}})
+	var de *DeleteError
+	if !errors.As(err, &de) || len(de.Failed) != 1 || de.Failed[0].Code != "SlowDown" {
+		t.Fatalf("batchDelete error = %v, want wal/a failing with SlowDown", err)
+	}
+	if len(mock.calls) != 3 {
+		t.Fatalf("DeleteObjects called %d times, want 3", len(mock.calls))
+	}
+}
@@ -3,71 +3,461 @@ package s3_log
 import (
 	"bytes"
 	"context"
+	"crypto/cipher"
 	"crypto/sha256"
 	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"math"
+	"reflect"
 	"strconv"
 	"strings"
 	"sync"
-	"reflect"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"golang.org/x/sync/semaphore"
+	"golang.org/x/sync/singleflight"
 )
 
 // Record is a WAL record stored as an S3 object.
 type Record struct {
 	Offset uint64
 	Data   []byte
+	// ETag of the object the record was read from, for IfMatch. Empty unless
+	// set by Read from S3, e.g. for records served from a cache or buffer.
+	ETag string
+	// PrevHash is the SHA-256 of the previous record's body under
+	// WithHashChain, nil for records written without it or not yet flushed.
+	PrevHash []byte
 }
 
 // WAL defines the minimal interface you used originally.
 type WAL interface {
 	Append(ctx context.Context, data []byte) (uint64, error)
-	Read(ctx context.Context, offset uint64) (Record, error)
+	Read(ctx context.Context, offset uint64, opts ...ReadOption) (Record, error)
 	LastRecord(ctx context.Context) (Record, error)
 }
 
 // S3WAL stores each record in its own S3 object under the configured prefix.
-// Object key format: <prefix>/<zero-padded-20-digit-offset>
+// Object key format: <prefix>/<zero-padded-20-digit-offset>, where the "/" can
+// be replaced with WithKeySeparator.
 type S3WAL struct {
-	client     *s3.Client
+	client     S3API
+	ownClient  bool // client built by NewS3WALFromConfig
+	accelerate bool // WithTransferAcceleration
+	dualStack  bool // WithDualStack
+	pathStyle  bool // WithForcePathStyle
 	bucketName string
 	prefix     string
+	separator  string // between prefix and offset, "/" unless WithKeySeparator
+	rootPrefix bool   // WithRootPrefix: an empty prefix means keys at the bucket root
+	delimited  bool   // WithDelimiterScoping: list direct children of the list prefix only
+
+	unknownKeyHandler func(key string) // WithUnknownKeyHandler, called for foreign keys
+	strictKeys        bool             // WithStrictKeys: foreign keys fail Recover and Truncate
+
+	checksumAlgorithm types.ChecksumAlgorithm // S3-native checksum, empty means none
+	acl               types.ObjectCannedACL   // canned ACL of written objects, empty means none
+	expectedOwner     string                  // account that must own the bucket, empty means unchecked
+	requestPayer      types.RequestPayer      // RequestPayer of every request, empty unless WithRequestPayer
+	hashChars         int                     // hex chars of the hash directory, 0 unless WithHashPrefix
+	codec             RecordCodec             // record body layout, sha256-checked by default
+	zstd              *zstdCompression        // compression for the default codec, nil means none
+	compressIf        func(data []byte) bool  // WithCompressionPredicate, nil means the default
+	aead              cipher.AEAD             // encryption for the default codec, nil means none
+	minRetained       int                     // records Truncate must keep, 0 means no guard
+	verifyWrites      bool                    // HeadObject every written key before acknowledging it
+	checkAmbiguous    bool                    // HeadObject the key after a PutObject failure without a clear answer
+	trustLength       bool                    // Truncate deletes the keys up to length without listing
+	deleteRetries     int                     // retries of keys DeleteObjects failed with a retryable code
+	deleteBackoff     Backoff                 // wait before each of those retries
 
-	mu     sync.Mutex // protects length
+	mu     sync.Mutex // protects length, recoveredAt and the segment buffer
 	length uint64     // last known offset, 0 means unknown/empty
+
+	// WithRecoverCacheTTL: Recover returns length without listing while
+	// recoveredAt is younger than recoverTTL. Writes that move length reset
+	// recoveredAt to zero.
+	recoverTTL   time.Duration
+	recoveredAt  time.Time
+	recoverGroup singleflight.Group
+
+	manifest      *manifestConfig // WithManifest; nil means every Recover lists
+	probeMax      uint64          // WithProbeRecover: find the length by HeadObject up to this offset
+	formatChecked bool            // the _created marker was checked or written; protected by mu
+
+	// segment mode (WithSegmentation); nil means one object per record
+	segment      *segmentConfig
+	pending      []Record // appended but not yet flushed, consecutive offsets
+	pendingBytes int
+	flushErr     error // last background flush failure, reported by Append
+	flushStop    chan struct{}
+	flushDone    chan struct{}
+	closeOnce    sync.Once
+
+	maintStop chan struct{} // StartMaintenance, nil while not running
+	maintDone chan struct{}
+
+	diskCache *diskCache    // WithDiskCache, nil means no cache
+	journal   *localJournal // WithLocalJournal, nil means no journal
+
+	// WithLastWriteCache: the record Append stored last, Offset 0 means none
+	lastWriteCache bool
+	lastWriteMu    sync.Mutex
+	lastWrite      Record
+
+	drainMu  sync.RWMutex   // protects draining against new operations
+	draining bool           // set by Drain, new operations fail with ErrDraining
+	inflight sync.WaitGroup // operations started and not finished
+
+	followInterval    time.Duration // first poll interval for Follow
+	followMaxInterval time.Duration // idle backoff cap for Follow
+	sqsClient         *sqs.Client   // event source for FollowViaSQS
+
+	failoverClient S3API // WithFailoverClient, nil means none
+	failoverBucket string
+
+	keyFormat      func(offset uint64) string        // WithKeyTransform, nil means %020d
+	keyParse       func(name string) (uint64, error) // WithKeyParse
+	observers      []Observer                        // WithObserver
+	opSlots        *semaphore.Weighted               // WithMaxConcurrentOps, nil means unlimited
+	noInitProbe    bool                              // WithoutInitProbe
+	externalDecode func(body []byte) (Record, error) // WithExternalFormat
+	dates          *datePartitions                   // WithDatePartitioning, nil means none
+
+	// WithHashChain: chainHash is the SHA-256 of the body stored at
+	// chainOffset, 0 means not cached. Protected by mu.
+	hashChain   bool
+	chainHash   []byte
+	chainOffset uint64
+
+	appendMiddleware []AppendMiddleware // WithAppendMiddleware, in order
+	allocator        OffsetAllocator    // WithOffsetAllocator, nil means length+1
+	adaptive         *adaptiveLimiter   // WithAdaptiveConcurrency, nil means fixed read concurrency
+
+	// event types by tag, see RegisterType
+	eventsMu   sync.RWMutex
+	eventTypes map[string]reflect.Type
 }
 
 // NewS3WAL constructs a WAL instance. It does NOT touch S3. Call Recover(ctx) to sync length.
-func NewS3WAL(client *s3.Client, bucketName, prefix string) *S3WAL {
+// It panics on the configuration errors Validate reports; NewS3WALE returns them.
+func NewS3WAL(client *s3.Client, bucketName, prefix string, opts ...Option) *S3WAL {
+	w := newS3WAL(sdkClient(client), bucketName, prefix, opts)
+	w.mustValidate()
+	w.start()
+	return w
+}
+
+// newS3WAL builds a configured S3WAL without starting background work.
+func newS3WAL(client S3API, bucketName, prefix string, opts []Option) *S3WAL {
 	// normalize prefix: remove leading/trailing slashes
 	trimmed := strings.Trim(prefix, "/")
-	return &S3WAL{
+	w := &S3WAL{
 		client:     client,
 		bucketName: bucketName,
 		prefix:     trimmed,
+		separator:  "/",
 		length:     0,
+
+		codec: checksumCodec{},
+
+		deleteRetries: defaultDeleteRetries,
+		deleteBackoff: defaultDeleteBackoff,
+
+		followInterval:    defaultFollowInterval,
+		followMaxInterval: defaultFollowMaxInterval,
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	if w.failoverClient != nil && w.failoverBucket == "" {
+		w.failoverBucket = w.bucketName
+	}
+	w.client = w.limitedClient(w.client)
+	w.failoverClient = w.limitedClient(w.failoverClient)
+	if c, ok := w.codec.(checksumCodec); ok {
+		c.zstd = w.zstd
+		c.compressIf = w.compressIf
+		c.aead = w.aead
+		w.codec = c
+	}
+	return w
+}
+
+// NewS3WALFromConfig is NewS3WALE with an S3 client built from cfg, which
+// WithTransferAcceleration, WithDualStack and WithForcePathStyle configure.
+func NewS3WALFromConfig(cfg aws.Config, bucketName, prefix string, opts ...Option) (*S3WAL, error) {
+	w := newS3WAL(nil, bucketName, prefix, opts)
+	w.client = s3.NewFromConfig(cfg, w.clientOptions)
+	w.ownClient = true
+	if err := w.Validate(); err != nil {
+		return nil, err
+	}
+	w.start()
+	return w, nil
+}
+
+// clientOptions applies the endpoint options to a client NewS3WALFromConfig builds.
+func (w *S3WAL) clientOptions(o *s3.Options) {
+	o.UseAccelerate = w.accelerate
+	o.UsePathStyle = o.UsePathStyle || w.pathStyle
+	if w.dualStack {
+		o.EndpointOptions.UseDualStackEndpoint = aws.DualStackEndpointStateEnabled
+	}
+	w.limitOptions(o)
+}
+
+// NewS3WALE is NewS3WAL that validates the configuration first, see Validate.
+func NewS3WALE(client *s3.Client, bucketName, prefix string, opts ...Option) (*S3WAL, error) {
+	w := newS3WAL(sdkClient(client), bucketName, prefix, opts)
+	if err := w.Validate(); err != nil {
+		return nil, err
 	}
+	w.start()
+	return w, nil
+}
+
+// mustValidate panics with the error Validate reports, for the constructors
+// that cannot return one.
+func (w *S3WAL) mustValidate() {
+	if err := w.Validate(); err != nil {
+		panic(err)
+	}
+}
+
+// Validate reports configuration errors NewS3WAL panics with, such as a
+// prefix that is empty after trimming slashes without WithRootPrefix: its keys
+// would live at "/<offset>" and every listing would scan the whole bucket.
+func (w *S3WAL) Validate() error {
+	if w.prefix == "" && !w.rootPrefix {
+		return fmt.Errorf("%w: prefix is empty, see WithRootPrefix", ErrInvalidPrefix)
+	}
+	if w.delimited && w.hashChars > 0 && strings.Contains(w.separator, "/") {
+		return errors.New("s3_log: WithDelimiterScoping cannot list the hash directories of WithHashPrefix with a \"/\" separator")
+	}
+	if (w.accelerate || w.dualStack || w.pathStyle) && !w.ownClient {
+		return errors.New("s3_log: WithTransferAcceleration, WithDualStack and WithForcePathStyle need NewS3WALFromConfig, or set them on the client")
+	}
+	if w.dates != nil && (w.hashChars > 0 || w.delimited) {
+		return errors.New("s3_log: WithDatePartitioning cannot be combined with WithHashPrefix or WithDelimiterScoping")
+	}
+	if w.probeMax > 0 && (w.segment != nil || w.dates != nil) {
+		return errors.New("s3_log: WithProbeRecover cannot be combined with WithSegmentation or WithDatePartitioning")
+	}
+	if w.manifest != nil && (w.segment != nil || w.dates != nil) {
+		// records past the manifest are found by probing the next offset's key
+		return errors.New("s3_log: WithManifest cannot be combined with WithSegmentation or WithDatePartitioning")
+	}
+	if _, ok := w.client.(*s3.Client); w.opSlots != nil && !ok {
+		return errors.New("s3_log: WithMaxConcurrentOps needs an *s3.Client, not another S3API")
+	}
+	if w.accelerate && w.pathStyle {
+		return errors.New("s3_log: WithTransferAcceleration needs virtual-hosted requests, drop WithForcePathStyle")
+	}
+	if (w.keyFormat == nil) != (w.keyParse == nil) {
+		return errors.New("s3_log: WithKeyTransform and WithKeyParse must be set together")
+	}
+	if w.keyFormat != nil {
+		if err := w.checkKeyTransform(); err != nil {
+			return err
+		}
+	}
+	if w.allocator != nil && (w.segment != nil || w.dates != nil || w.hashChain || w.probeMax > 0 || w.manifest != nil) {
+		// they all rely on records at consecutive offsets
+		return errors.New("s3_log: WithOffsetAllocator cannot be combined with WithSegmentation, WithDatePartitioning, WithHashChain, WithProbeRecover or WithManifest")
+	}
+	if w.diskCache != nil && w.diskCache.initErr != nil {
+		return w.diskCache.initErr
+	}
+	if w.journal != nil && w.journal.initErr != nil {
+		return w.journal.initErr
+	}
+	if _, ok := w.codec.(checksumCodec); w.hashChain && !ok {
+		return errors.New("s3_log: WithHashChain needs the default codec")
+	}
+	return nil
+}
+
+// start launches the background work the options ask for.
+func (w *S3WAL) start() {
+	if w.segment != nil && w.segment.flushInterval > 0 {
+		w.flushStop = make(chan struct{})
+		w.flushDone = make(chan struct{})
+		go w.runFlusher()
+	}
+}
+
+// bucketOwner is the ExpectedBucketOwner of every request, nil unless
+// WithExpectedBucketOwner is set.
+func (w *S3WAL) bucketOwner() *string {
+	if w.expectedOwner == "" {
+		return nil
+	}
+	return aws.String(w.expectedOwner)
+}
+
+// listPrefix is the prefix shared by every record key: <prefix><separator>,
+// or "" for a WAL at the bucket root (WithRootPrefix).
+func (w *S3WAL) listPrefix() string {
+	if w.prefix == "" && w.rootPrefix {
+		return ""
+	}
+	return w.prefix + w.separator
+}
+
+// listDelimiter is the Delimiter of every listing, nil unless
+// WithDelimiterScoping is set.
+func (w *S3WAL) listDelimiter() *string {
+	if !w.delimited {
+		return nil
+	}
+	return aws.String("/")
 }
 
 // getObjectKey builds the object key for an offset.
 func (w *S3WAL) getObjectKey(offset uint64) string {
-	return w.prefix + "/" + fmt.Sprintf("%020d", offset)
+	if w.dates != nil {
+		return w.listPrefix() + w.dates.dir(offset) + w.offsetName(offset)
+	}
+	return w.listPrefix() + w.hashDir(offset) + w.offsetName(offset)
 }
 
-// getOffsetFromKey extracts offset from an object key. It handles keys like "prefix/000...".
+// offsetName is the part of a key that encodes offset: zero-padded to 20
+// digits, or as set by WithKeyTransform.
+func (w *S3WAL) offsetName(offset uint64) string {
+	if w.keyFormat != nil {
+		return w.keyFormat(offset)
+	}
+	return fmt.Sprintf("%020d", offset)
+}
+
+// parseOffsetName reverses offsetName.
+func (w *S3WAL) parseOffsetName(name string) (uint64, error) {
+	if w.keyParse != nil {
+		return w.keyParse(name)
+	}
+	return parseOffset(name)
+}
+
+// checkKeyTransform spot-checks that WithKeyParse reverses WithKeyTransform
+// and that names sort in offset order.
+func (w *S3WAL) checkKeyTransform() error {
+	var prev string
+	for i, offset := range []uint64{1, 2, 9, 10, 99, 100, 1 << 32, math.MaxUint64} {
+		name := w.keyFormat(offset)
+		if got, err := w.keyParse(name); err != nil || got != offset {
+			return fmt.Errorf("s3_log: WithKeyParse(%q) = %d, %v, want offset %d", name, got, err, offset)
+		}
+		if i > 0 && name <= prev {
+			return fmt.Errorf("s3_log: WithKeyTransform names out of order: %q for %d sorts before %q", name, offset, prev)
+		}
+		prev = name
+	}
+	return nil
+}
+
+// hashDir returns the "<hex><separator>" directory WithHashPrefix spreads an
+// offset into, or "" when hash prefixes are off.
+func (w *S3WAL) hashDir(offset uint64) string {
+	if w.hashChars == 0 {
+		return ""
+	}
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], offset)
+	sum := sha256.Sum256(b[:])
+	return hex.EncodeToString(sum[:])[:w.hashChars] + w.separator
+}
+
+// keyName strips the list prefix and the directories from an object key,
+// leaving the part that encodes offsets.
+func (w *S3WAL) keyName(key string) (string, error) {
+	if strings.HasSuffix(key, "/") {
+		// a folder marker left behind by a console or sync tool
+		return "", fmt.Errorf("invalid key format: %q is a directory marker", key)
+	}
+	name, ok := strings.CutPrefix(key, w.listPrefix())
+	if ok && w.hashChars > 0 {
+		if len(name) < w.hashChars || !isLowerHex(name[:w.hashChars]) {
+			ok = false
+		} else {
+			name, ok = strings.CutPrefix(name[w.hashChars:], w.separator)
+		}
+	}
+	if ok && w.dates != nil {
+		_, name, ok = splitDatePartition(name)
+	}
+	if w.delimited && strings.Contains(name, "/") {
+		// a key of a WAL nested below this one
+		ok = false
+	}
+	if i := strings.LastIndex(name, "/"); ok && i >= 0 && w.keyFormat == nil {
+		// only the final path segment encodes offsets, whatever directories
+		// sharding or partitioning put before it; WithKeyTransform names
+		// may contain "/" themselves
+		name = name[i+1:]
+	}
+	if !ok || name == "" {
+		return "", fmt.Errorf("invalid key format: %q", key)
+	}
+	return name, nil
+}
+
+// unknownKey reports a listed key that is neither a record, a segment nor
+// one of the WAL's own "_"-prefixed objects to the WithUnknownKeyHandler, and
+// returns ErrUnknownKey for it under WithStrictKeys.
+func (w *S3WAL) unknownKey(key string) error {
+	if strings.HasPrefix(key, w.listPrefix()+"_") {
+		// replication checkpoints, the key index, Init probes
+		return nil
+	}
+	if w.unknownKeyHandler != nil {
+		w.unknownKeyHandler(key)
+	}
+	if w.strictKeys {
+		return fmt.Errorf("%w: %q", ErrUnknownKey, key)
+	}
+	return nil
+}
+
+// isLowerHex reports whether s consists of lowercase hex digits only.
+func isLowerHex(s string) bool {
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// getOffsetFromKey extracts offset from an object key. It handles keys like
+// "prefix/000...", "prefix/ab/000..." under WithHashPrefix and
+// "prefix/2024/01/02/000..." under WithDatePartitioning, and parses the final
+// path segment of a key with any other directories in between, unless
+// WithDelimiterScoping says those belong to nested WALs. A directory marker
+// ending in "/" is rejected.
 func (w *S3WAL) getOffsetFromKey(key string) (uint64, error) {
-	// find last slash and take substring after it
-	idx := strings.LastIndexByte(key, '/')
-	if idx < 0 || idx == len(key)-1 {
-		return 0, fmt.Errorf("invalid key format: %q", key)
+	numStr, err := w.keyName(key)
+	if err != nil {
+		return 0, err
 	}
-	numStr := key[idx+1:]
-	return strconv.ParseUint(numStr, 10, 64)
+	return w.parseOffsetName(numStr)
+}
+
+// parseOffset parses a zero-padded 20-digit offset. Anything else is rejected so
+// that keys of a sibling WAL sharing the list prefix are never mistaken for ours.
+func parseOffset(s string) (uint64, error) {
+	if len(s) != 20 {
+		return 0, fmt.Errorf("invalid offset %q: want 20 digits", s)
+	}
+	return strconv.ParseUint(s, 10, 64)
 }
 
 // prepareBody writes: [8-byte offset BE][data][32-byte sha256(offset+data)]
@@ -110,112 +500,698 @@ func validateChecksum(full []byte) bool {
 	return bytes.Equal(sum[:], stored)
 }
 
+// preparePlainBody writes: [8-byte offset BE][data]. It is used instead of
+// prepareBody when the in-body checksum is disabled.
+func preparePlainBody(offset uint64, data []byte) []byte {
+	body := make([]byte, 8+len(data))
+	binary.BigEndian.PutUint64(body, offset)
+	copy(body[8:], data)
+	return body
+}
+
+// encodeBody builds the stored body of a record with the configured codec.
+func (w *S3WAL) encodeBody(offset uint64, data []byte) ([]byte, error) {
+	return w.codec.Marshal(Record{Offset: offset, Data: data})
+}
+
+// Length returns the last known offset (0 for an empty or not yet recovered WAL)
+// without touching S3.
+func (w *S3WAL) Length() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.length
+}
+
+// NextOffset returns the offset the next Append will use, without touching S3
+// or reserving it. Under WithOffsetAllocator it is only a hint, one past the
+// highest offset stored so far: the allocator picks the offset, and asking it
+// would use one up.
+func (w *S3WAL) NextOffset() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.length + 1
+}
+
 // Append uploads a new object and bumps w.length. It's mutex-protected.
 func (w *S3WAL) Append(ctx context.Context, data []byte) (uint64, error) {
+	offset, _, err := w.append(ctx, data, 0, true)
+	return offset, err
+}
+
+// append is Append, storing the record as its own object tagged for expiry
+// after ttl if ttl is positive. It also returns the stored body, or nil for a
+// record buffered by WithSegmentation. Without middleware data is stored as
+// is, for data that already went through the WithAppendMiddleware chain.
+func (w *S3WAL) append(ctx context.Context, data []byte, ttl time.Duration, middleware bool) (_ uint64, body []byte, err error) {
+	if len(w.observers) > 0 {
+		defer w.observe(OpAppend, time.Now(), &err)
+	}
+	if err := w.beginOp(); err != nil {
+		return 0, nil, err
+	}
+	defer w.endOp()
+	if middleware {
+		if data, err = w.applyAppendMiddleware(ctx, data); err != nil {
+			return 0, nil, err
+		}
+	}
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
-	next := w.length + 1
-	body, err := prepareBody(next, data)
+	next, err := w.allocateOffset()
+	if err != nil {
+		return 0, nil, err
+	}
+	if w.segment != nil {
+		if ttl <= 0 {
+			if err := w.appendToSegment(ctx, next, data); err != nil {
+				return 0, nil, err
+			}
+			return next, nil, nil
+		}
+		if err := w.flushLocked(ctx); err != nil {
+			return 0, nil, fmt.Errorf("flush before append: %w", err)
+		}
+	}
+	var prev []byte
+	if w.hashChain {
+		if prev, err = w.chainPrev(ctx, next); err != nil {
+			return 0, nil, err
+		}
+	}
+	if w.dates != nil {
+		w.dates.startAt(next, time.Now())
+	}
+	body, err = w.codec.Marshal(Record{Offset: next, Data: data, PrevHash: prev})
 	if err != nil {
-		return 0, fmt.Errorf("prepare body: %w", err)
+		return 0, nil, fmt.Errorf("prepare body: %w", err)
 	}
 
 	input := &s3.PutObjectInput{
-    Bucket:      aws.String(w.bucketName),
-    Key:         aws.String(w.getObjectKey(next)),
-    Body:        bytes.NewReader(body),
-    
-}
+		Bucket:              aws.String(w.bucketName),
+		ACL:                 w.acl,
+		ExpectedBucketOwner: w.bucketOwner(),
+		RequestPayer:        w.requestPayer,
+		Key:                 aws.String(w.getObjectKey(next)),
+		Body:                bytes.NewReader(body),
+		ChecksumAlgorithm:   w.checksumAlgorithm,
+	}
+	if ttl > 0 {
+		input.Tagging = aws.String(expiryTagging(ttl))
+		input.Expires = aws.Time(time.Now().Add(ttl))
+	}
 
+	if w.journal != nil {
+		if err := w.journal.append(next, data); err != nil {
+			return 0, nil, err
+		}
+	}
+	if _, err := w.client.PutObject(ctx, input, s3Options(ctx)...); err != nil {
+		if !w.checkAmbiguous || !isUnavailable(err) || !w.objectExists(ctx, *input.Key) {
+			if w.journal != nil {
+				w.journal.undo()
+			}
+			return 0, nil, fmt.Errorf("put object (offset=%d): %w", next, err)
+		}
+		// the write went through, only its response was lost
+	}
+	if w.journal != nil {
+		w.journal.reset(nil)
+	}
+	if err := w.verifyVisible(ctx, *input.Key); err != nil {
+		return 0, nil, err
+	}
 
-	if _, err := w.client.PutObject(ctx, input); err != nil {
-		return 0, fmt.Errorf("put object (offset=%d): %w", next, err)
+	w.length = max(w.length, next)
+	w.recoveredAt = time.Time{}
+	if w.hashChain {
+		w.setChainTail(next, chainHash(body))
+	}
+	if ttl <= 0 {
+		// an expiring record may be gone by the time it is read
+		w.setLastWrite(Record{Offset: next, Data: data, PrevHash: prev})
 	}
+	return next, body, nil
+}
 
-	w.length = next
-	return next, nil
+// AppendCopyOf appends a copy of the data of the record at srcOffset and
+// returns the new offset. A server-side CopyObject is not possible: every body
+// embeds its offset, which the checksum covers too, so a copied body would
+// fail validation under its new key. The record is read, re-stamped with the
+// new offset by the codec and written like any Append instead, except that
+// the data, stored already, does not go through WithAppendMiddleware again.
+func (w *S3WAL) AppendCopyOf(ctx context.Context, srcOffset uint64) (uint64, error) {
+	rec, err := w.Read(ctx, srcOffset)
+	if err != nil {
+		return 0, fmt.Errorf("read source record: %w", err)
+	}
+	offset, _, err := w.append(ctx, rec.Data, 0, false)
+	return offset, err
 }
 
-// Read downloads object at offset and returns parsed Record.
-func (w *S3WAL) Read(ctx context.Context, offset uint64) (Record, error) {
+// AppendAt stores data at exactly offset instead of the next free one, e.g. to
+// replay a foreign log's offset space verbatim, and raises the cached length
+// to offset if it is behind. Gaps are allowed in this mode: offsets skipped
+// over are simply absent, and an existing record at offset is overwritten.
+// In segment mode buffered records are flushed first and the record is
+// stored as its own object.
+func (w *S3WAL) AppendAt(ctx context.Context, offset uint64, data []byte) error {
+	return w.appendAt(ctx, offset, data, false, true)
+}
+
+// AppendAtIfAbsent is AppendAt that never overwrites: it uses a conditional
+// write and returns ErrOffsetExists if an object is already stored at offset.
+// A record held by a segment is not detected.
+func (w *S3WAL) AppendAtIfAbsent(ctx context.Context, offset uint64, data []byte) error {
+	return w.appendAt(ctx, offset, data, true, true)
+}
+
+// Overwrite replaces the data of the existing record at offset, keeping the
+// offset. Unlike Append it allocates nothing and does not change the length;
+// it is meant for logs used as mutable stores (e.g. configuration) and breaks
+// the append-only assumption readers may rely on, as a record already read or
+// followed can change afterwards.
+//
+// The swap is atomic: the record's ETag is read first and the write only
+// succeeds if the object is unchanged, so of two concurrent Overwrites exactly
+// one wins and the other fails. It returns ErrOffsetNotFound if there is no
+// record at offset; use AppendAt to write regardless. Records packed into a
+// segment cannot be overwritten, buffered ones are replaced in memory.
+func (w *S3WAL) Overwrite(ctx context.Context, offset uint64, data []byte) error {
+	if err := w.beginOp(); err != nil {
+		return err
+	}
+	defer w.endOp()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.pending) > 0 && offset >= w.pending[0].Offset && offset-w.pending[0].Offset < uint64(len(w.pending)) {
+		if w.journal != nil {
+			if err := w.journal.append(offset, data); err != nil {
+				return err
+			}
+		}
+		rec := &w.pending[offset-w.pending[0].Offset]
+		w.pendingBytes += len(data) - len(rec.Data)
+		rec.Data = append([]byte(nil), data...)
+		return nil
+	}
+
 	key := w.getObjectKey(offset)
-	input := &s3.GetObjectInput{
-		Bucket: aws.String(w.bucketName),
-		Key:    aws.String(key),
+	headInput := &s3.HeadObjectInput{
+		Bucket:              aws.String(w.bucketName),
+		ExpectedBucketOwner: w.bucketOwner(),
+		RequestPayer:        w.requestPayer,
+		Key:                 aws.String(key),
+	}
+	head, err := w.client.HeadObject(ctx, headInput, s3Options(ctx)...)
+	if err != nil && isNotFound(err) && w.reloadPartition(ctx, offset) {
+		key = w.getObjectKey(offset)
+		headInput.Key = aws.String(key)
+		head, err = w.client.HeadObject(ctx, headInput, s3Options(ctx)...)
 	}
-	out, err := w.client.GetObject(ctx, input)
 	if err != nil {
-		return Record{}, fmt.Errorf("get object %s: %w", key, err)
+		if !isNotFound(err) {
+			return fmt.Errorf("head object (offset=%d): %w", offset, err)
+		}
+		_, _, _, found, lerr := w.locateSegment(ctx, offset)
+		if lerr != nil {
+			return lerr
+		}
+		if found {
+			return fmt.Errorf("offset %d is stored in a segment and cannot be overwritten", offset)
+		}
+		return fmt.Errorf("offset %d: %w", offset, ErrOffsetNotFound)
 	}
-	defer out.Body.Close()
 
-	data, err := io.ReadAll(out.Body)
+	body, err := w.encodeBody(offset, data)
 	if err != nil {
-		return Record{}, fmt.Errorf("read object %s body: %w", key, err)
+		return fmt.Errorf("prepare body: %w", err)
+	}
+	input := &s3.PutObjectInput{
+		Bucket:              aws.String(w.bucketName),
+		ACL:                 w.acl,
+		ExpectedBucketOwner: w.bucketOwner(),
+		RequestPayer:        w.requestPayer,
+		Key:                 aws.String(key),
+		Body:                bytes.NewReader(body),
+		ChecksumAlgorithm:   w.checksumAlgorithm,
+		IfMatch:             head.ETag,
 	}
+	if _, err := w.client.PutObject(ctx, input, s3Options(ctx)...); err != nil {
+		if isPreconditionFailed(err) {
+			return fmt.Errorf("overwrite offset %d: record changed concurrently: %w", offset, err)
+		}
+		return fmt.Errorf("put object (offset=%d): %w", offset, err)
+	}
+	if w.diskCache != nil {
+		w.diskCache.remove(offset)
+	}
+	w.dropLastWrite(offset, offset)
+	w.chainOffset = 0
+	return w.verifyVisible(ctx, key)
+}
 
-	if len(data) < 8+sha256.Size {
-		return Record{}, fmt.Errorf("invalid record (too short) for key %s", key)
+// appendAt is AppendAt, or AppendAtIfAbsent with ifAbsent. Without middleware
+// data is stored as is, like in append.
+func (w *S3WAL) appendAt(ctx context.Context, offset uint64, data []byte, ifAbsent, middleware bool) error {
+	if err := w.beginOp(); err != nil {
+		return err
 	}
+	defer w.endOp()
+	if offset == 0 {
+		return errors.New("offset 0 is not a valid record offset")
+	}
+	if middleware {
+		var err error
+		if data, err = w.applyAppendMiddleware(ctx, data); err != nil {
+			return err
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
 
-	// read offset prefix
-	var storedOffset uint64
-	if err := binary.Read(bytes.NewReader(data[:8]), binary.BigEndian, &storedOffset); err != nil {
-		return Record{}, fmt.Errorf("parse offset from object %s: %w", key, err)
+	if err := w.flushLocked(ctx); err != nil {
+		return fmt.Errorf("flush before append: %w", err)
 	}
-	if storedOffset != offset {
-		return Record{}, fmt.Errorf("offset mismatch for key %s: expected %d, got %d", key, offset, storedOffset)
+	if w.diskCache != nil {
+		w.diskCache.remove(offset)
+	}
+	w.dropLastWrite(offset, offset)
+	if w.dates != nil && offset > w.length {
+		w.dates.startAt(offset, time.Now())
+	}
+	body, err := w.encodeBody(offset, data)
+	if err != nil {
+		return fmt.Errorf("prepare body: %w", err)
 	}
 
-	if !validateChecksum(data) {
-		return Record{}, fmt.Errorf("checksum mismatch for offset %d (key %s)", offset, key)
+	input := &s3.PutObjectInput{
+		Bucket:              aws.String(w.bucketName),
+		ACL:                 w.acl,
+		ExpectedBucketOwner: w.bucketOwner(),
+		RequestPayer:        w.requestPayer,
+		Key:                 aws.String(w.getObjectKey(offset)),
+		Body:                bytes.NewReader(body),
+		ChecksumAlgorithm:   w.checksumAlgorithm,
+	}
+	if ifAbsent {
+		input.IfNoneMatch = aws.String("*")
+	}
+	if _, err := w.client.PutObject(ctx, input, s3Options(ctx)...); err != nil {
+		if ifAbsent && isPreconditionFailed(err) {
+			return fmt.Errorf("put object (offset=%d): %w", offset, ErrOffsetExists)
+		}
+		return fmt.Errorf("put object (offset=%d): %w", offset, err)
+	}
+	if err := w.verifyVisible(ctx, *input.Key); err != nil {
+		return err
+	}
+
+	w.length = max(w.length, offset)
+	w.recoveredAt = time.Time{}
+	w.chainOffset = 0
+	return nil
+}
+
+// ReadOption configures a single Read.
+type ReadOption func(*readConfig)
+
+type readConfig struct {
+	ifMatch string
+}
+
+// IfMatch makes Read fail with ErrRecordChanged unless the object holding the
+// record still has the given ETag, as returned in Record.ETag by an earlier
+// Read. It detects records overwritten or truncated and rewritten since.
+func IfMatch(etag string) ReadOption {
+	return func(c *readConfig) {
+		c.ifMatch = etag
+	}
+}
+
+// Read downloads object at offset and returns parsed Record.
+// Records buffered by WithSegmentation are served from memory, and records
+// packed into segment objects are located and decoded transparently. A damaged
+// record fails with an error wrapping ErrRecordTooShort, ErrChecksumMismatch
+// or ErrOffsetMismatch. Compression is detected from each record's header, so
+// a log written partly before WithZstdCompression was enabled reads without
+// it; only WithZstdDictionary records need the dictionary configured.
+func (w *S3WAL) Read(ctx context.Context, offset uint64, opts ...ReadOption) (_ Record, err error) {
+	if len(w.observers) > 0 {
+		defer w.observe(OpRead, time.Now(), &err)
+	}
+	if err := w.beginOp(); err != nil {
+		return Record{}, err
+	}
+	defer w.endOp()
+	var cfg readConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if w.segment != nil {
+		w.mu.Lock()
+		rec, ok := w.pendingRecord(offset)
+		w.mu.Unlock()
+		if ok {
+			if cfg.ifMatch != "" {
+				// a buffered record has no ETag yet, so it is not the one read before
+				return Record{}, fmt.Errorf("offset %d: %w", offset, ErrRecordChanged)
+			}
+			return rec, nil
+		}
 	}
 
-	recordData := make([]byte, len(data)-8-sha256.Size)
-	copy(recordData, data[8:len(data)-sha256.Size])
+	// the caches cannot tell whether the object changed
+	if cfg.ifMatch == "" {
+		if rec, ok := w.cachedLastWrite(offset); ok {
+			return rec, nil
+		}
+	}
+	if w.diskCache != nil && cfg.ifMatch == "" {
+		if body, ok := w.diskCache.get(offset); ok {
+			rec, err := w.decodeRecord(w.diskCache.path(offset), offset, body)
+			if err == nil {
+				return rec, nil
+			}
+			// corrupt or from an incompatible codec, refetch
+			w.diskCache.remove(offset)
+		}
+	}
+	return w.readStored(ctx, offset, cfg.ifMatch)
+}
 
-	return Record{
-		Offset: storedOffset,
-		Data:   recordData,
-	}, nil
+// readStored reads the record at offset from S3, from its own object or the
+// segment holding it. A non-empty ifMatch is passed on to the GetObject.
+func (w *S3WAL) readStored(ctx context.Context, offset uint64, ifMatch string) (Record, error) {
+	rec, _, err := w.readStoredMeta(ctx, offset, ifMatch)
+	return rec, err
+}
+
+// readStoredMeta is readStored that also returns the metadata of the object
+// the record was read from.
+func (w *S3WAL) readStoredMeta(ctx context.Context, offset uint64, ifMatch string) (Record, ObjectMeta, error) {
+	changed := func(err error) error {
+		if isPreconditionFailed(err) {
+			return fmt.Errorf("offset %d: %w", offset, ErrRecordChanged)
+		}
+		return err
+	}
+	key := w.getObjectKey(offset)
+	data, meta, err := w.getObject(ctx, key, ifMatch)
+	if err != nil {
+		if !isNotFound(err) {
+			return Record{}, ObjectMeta{}, changed(err)
+		}
+		if w.reloadPartition(ctx, offset) {
+			return w.readStoredMeta(ctx, offset, ifMatch)
+		}
+		segKey, first, last, found, lerr := w.locateSegment(ctx, offset)
+		if lerr != nil {
+			return Record{}, ObjectMeta{}, lerr
+		}
+		if !found {
+			return Record{}, ObjectMeta{}, err
+		}
+		segData, segMeta, serr := w.getObject(ctx, segKey, ifMatch)
+		if serr != nil {
+			return Record{}, ObjectMeta{}, changed(serr)
+		}
+		rec, err := w.decodeSegmentRecord(segKey, segData, first, last, offset)
+		if err == nil && w.diskCache != nil {
+			if body, err := w.encodeBody(rec.Offset, rec.Data); err == nil {
+				w.diskCache.put(offset, body)
+			}
+		}
+		rec.ETag = segMeta.ETag
+		return rec, segMeta, err
+	}
+	rec, err := w.decodeRecord(key, offset, data)
+	if err == nil && w.diskCache != nil {
+		w.diskCache.put(offset, data)
+	}
+	rec.ETag = meta.ETag
+	return rec, meta, err
+}
+
+// ReadRecord reads and validates the record at offset of the WAL stored under
+// bucket/prefix without keeping an S3WAL around, e.g. for one-off reads across
+// streams. opts must describe the layout the WAL was written with (key
+// separator, codec, ...) when it differs from the default.
+func ReadRecord(ctx context.Context, client *s3.Client, bucket, prefix string, offset uint64, opts ...Option) (Record, error) {
+	// no background work is started, so there is nothing to close
+	return newS3WAL(sdkClient(client), bucket, prefix, opts).Read(ctx, offset)
+}
+
+// getBody downloads the full body of an object.
+func (w *S3WAL) getBody(ctx context.Context, key string) ([]byte, error) {
+	data, _, err := w.getObject(ctx, key, "")
+	return data, err
+}
+
+// getObject downloads the full body of an object and returns it with the
+// object's metadata. A non-empty ifMatch makes the request conditional on it.
+func (w *S3WAL) getObject(ctx context.Context, key, ifMatch string) ([]byte, ObjectMeta, error) {
+	var data []byte
+	var meta ObjectMeta
+	err := w.withFailover(func(client S3API, bucket string) error {
+		input := &s3.GetObjectInput{
+			Bucket:              aws.String(bucket),
+			ExpectedBucketOwner: w.bucketOwner(),
+			RequestPayer:        w.requestPayer,
+			Key:                 aws.String(key),
+		}
+		if ifMatch != "" {
+			input.IfMatch = aws.String(ifMatch)
+		}
+		if w.checksumAlgorithm != "" {
+			// the SDK validates the object against its stored checksum
+			input.ChecksumMode = types.ChecksumModeEnabled
+		}
+		out, err := client.GetObject(ctx, input, s3Options(ctx)...)
+		if err != nil {
+			return fmt.Errorf("get object %s: %w", key, err)
+		}
+		defer out.Body.Close()
+
+		data, err = io.ReadAll(out.Body)
+		if err != nil {
+			if w.checksumAlgorithm != "" && isSDKChecksumMismatch(err) {
+				return fmt.Errorf("read object %s body: %w: %w", key, ErrS3ChecksumMismatch, err)
+			}
+			return fmt.Errorf("read object %s body: %w", key, err)
+		}
+		if w.checksumAlgorithm != "" {
+			if err := verifyS3Checksum(key, data, out); err != nil {
+				return err
+			}
+		}
+		meta = ObjectMeta{
+			Key:           key,
+			ETag:          aws.ToString(out.ETag),
+			LastModified:  aws.ToTime(out.LastModified),
+			ContentLength: aws.ToInt64(out.ContentLength),
+		}
+		return nil
+	})
+	return data, meta, err
+}
+
+// withFailover runs the read fn against the primary client and bucket, and
+// again against the WithFailoverClient ones if the primary is unavailable.
+func (w *S3WAL) withFailover(fn func(client S3API, bucket string) error) error {
+	err := fn(w.client, w.bucketName)
+	if err != nil && w.failoverClient != nil && isUnavailable(err) {
+		if ferr := fn(w.failoverClient, w.failoverBucket); ferr != nil {
+			return fmt.Errorf("%w (failover: %w)", err, ferr)
+		}
+		return nil
+	}
+	return err
+}
+
+// Write verification (WithReadAfterWriteVerification) retries HeadObject up
+// to verifyAttempts times, doubling the delay between attempts.
+const (
+	verifyAttempts = 5
+	verifyBackoff  = 50 * time.Millisecond
+)
+
+// verifyVisible waits until key can be seen by HeadObject. It is a no-op
+// unless WithReadAfterWriteVerification is set.
+func (w *S3WAL) verifyVisible(ctx context.Context, key string) error {
+	if !w.verifyWrites {
+		return nil
+	}
+	delay := verifyBackoff
+	for attempt := 1; ; attempt++ {
+		_, err := w.client.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket:              aws.String(w.bucketName),
+			ExpectedBucketOwner: w.bucketOwner(),
+			RequestPayer:        w.requestPayer,
+			Key:                 aws.String(key),
+		}, s3Options(ctx)...)
+		if err == nil {
+			return nil
+		}
+		if !isNotFound(err) || attempt == verifyAttempts {
+			return fmt.Errorf("verify object %s is visible: %w", key, err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// ambiguousWriteTimeout bounds the HeadObject that resolves an ambiguous
+// Append, which also runs after ctx is cancelled.
+const ambiguousWriteTimeout = 10 * time.Second
+
+// isUnavailable reports whether S3 did not answer, or answered with a server
+// error: a failed write may still have been applied, and a failed read may
+// succeed against a replica. A 4xx response is a definite answer.
+func isUnavailable(err error) bool {
+	var respErr *awshttp.ResponseError
+	return !errors.As(err, &respErr) || respErr.HTTPStatusCode() >= 500
+}
+
+// objectExists reports whether HeadObject finds key; any error counts as no.
+func (w *S3WAL) objectExists(ctx context.Context, key string) bool {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), ambiguousWriteTimeout)
+	defer cancel()
+	_, err := w.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:              aws.String(w.bucketName),
+		ExpectedBucketOwner: w.bucketOwner(),
+		RequestPayer:        w.requestPayer,
+		Key:                 aws.String(key),
+	}, s3Options(ctx)...)
+	return err == nil
+}
+
+// decodeRecord parses and validates a record body read from key.
+func (w *S3WAL) decodeRecord(key string, offset uint64, data []byte) (Record, error) {
+	if w.externalDecode != nil && !isNativeBody(data) {
+		rec, err := w.externalDecode(data)
+		if err != nil {
+			return Record{}, fmt.Errorf("decode external record %s: %w", key, err)
+		}
+		if rec.Offset == 0 {
+			rec.Offset = offset
+		}
+		if rec.Offset != offset {
+			return Record{}, fmt.Errorf("key %s: %w: expected %d, got %d", key, ErrOffsetMismatch, offset, rec.Offset)
+		}
+		return rec, nil
+	}
+	rec, err := w.codec.Unmarshal(data)
+	if err != nil {
+		return Record{}, fmt.Errorf("decode record %s: %w", key, err)
+	}
+	if rec.Offset != offset {
+		return Record{}, fmt.Errorf("key %s: %w: expected %d, got %d", key, ErrOffsetMismatch, offset, rec.Offset)
+	}
+	return rec, nil
 }
 
 // LastRecord finds the object with the highest offset and returns it.
 // It updates w.length accordingly and uses a mutex to avoid races.
 func (w *S3WAL) LastRecord(ctx context.Context) (Record, error) {
+	return w.lastRecord(ctx, nil)
+}
+
+// LastRecordWithStats is LastRecord that also reports the listing it did.
+func (w *S3WAL) LastRecordWithStats(ctx context.Context) (Record, ScanStats, error) {
+	var stats ScanStats
+	rec, err := w.lastRecord(ctx, &stats)
+	return rec, stats, err
+}
+
+func (w *S3WAL) lastRecord(ctx context.Context, stats *ScanStats) (Record, error) {
+	if err := w.beginOp(); err != nil {
+		return Record{}, err
+	}
+	defer w.endOp()
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
-	// List objects with prefix + "/"
-	prefix := w.prefix + "/"
-	input := &s3.ListObjectsV2Input{
-		Bucket: aws.String(w.bucketName),
-		Prefix: aws.String(prefix),
+	if n := len(w.pending); n > 0 {
+		rec, _ := w.pendingRecord(w.pending[n-1].Offset)
+		return rec, nil
+	}
+	if w.trustLength && w.length > 0 {
+		rec, err := w.readStored(ctx, w.length, "")
+		if err == nil || !isNotFound(err) {
+			return rec, err
+		}
+		// stale, e.g. truncated by another writer: list to find the last record
+	}
+	if w.probeMax > 0 {
+		length, err := w.probeLength(ctx)
+		if err != nil {
+			return Record{}, err
+		}
+		if length == 0 {
+			if w.length, err = w.trimmedLength(ctx); err != nil {
+				return Record{}, err
+			}
+			return Record{}, fmt.Errorf("WAL is empty")
+		}
+		w.length = length
+		return w.readStored(ctx, length, "")
 	}
-
-	paginator := s3.NewListObjectsV2Paginator(w.client, input)
 
 	var lastKey string
-	for paginator.HasMorePages() {
-		page, err := paginator.NextPage(ctx)
-		if err != nil {
-			return Record{}, fmt.Errorf("list objects: %w", err)
+	var lastOffset uint64
+	err := w.withFailover(func(client S3API, bucket string) error {
+		// List objects with prefix + separator
+		prefix := w.listPrefix()
+		input := &s3.ListObjectsV2Input{
+			Bucket:              aws.String(bucket),
+			ExpectedBucketOwner: w.bucketOwner(),
+			RequestPayer:        w.requestPayer,
+			Prefix:              aws.String(prefix),
+			Delimiter:           w.listDelimiter(),
 		}
-		// pick the last key of this page if any; because keys are lexicographically ordered,
-		// the last key across all pages will be the last key on the last non-empty page.
-		if len(page.Contents) > 0 {
-			lastKey = *page.Contents[len(page.Contents)-1].Key
+
+		paginator := s3.NewListObjectsV2Paginator(client, input)
+
+		lastKey, lastOffset = "", 0
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx, s3Options(ctx)...)
+			if err != nil {
+				return fmt.Errorf("list objects: %w", err)
+			}
+			stats.addPage(page)
+			// pick the key with the highest offset; key order only follows offset
+			// order without WithHashPrefix, so compare rather than take the last key.
+			// Keys that do not parse (e.g. a sibling WAL sharing the list prefix) are skipped.
+			for _, obj := range page.Contents {
+				key := aws.ToString(obj.Key)
+				if _, last, err := w.getRangeFromKey(key); err == nil && (lastKey == "" || last > lastOffset) {
+					lastKey, lastOffset = key, last
+				}
+			}
 		}
+		return nil
+	})
+	if err != nil {
+		return Record{}, err
 	}
 
 	if lastKey == "" {
-		// WAL empty
-		w.length = 0
+		// WAL empty, but offsets up to the trim marker were handed out
+		if w.length, err = w.trimmedLength(ctx); err != nil {
+			return Record{}, err
+		}
 		return Record{}, fmt.Errorf("WAL is empty")
 	}
 
-	offset, err := w.getOffsetFromKey(lastKey)
+	first, offset, err := w.getRangeFromKey(lastKey)
 	if err != nil {
 		return Record{}, fmt.Errorf("parse offset from last key %s: %w", lastKey, err)
 	}
@@ -223,37 +1199,125 @@ func (w *S3WAL) LastRecord(ctx context.Context) (Record, error) {
 	// update cached length
 	w.length = offset
 
-	// read and return the record
-	return w.Read(ctx, offset)
+	// read and return the record; the last key may be a segment
+	data, err := w.getBody(ctx, lastKey)
+	if err != nil {
+		return Record{}, err
+	}
+	if isSegment(data) {
+		return w.decodeSegmentRecord(lastKey, data, first, offset, offset)
+	}
+	return w.decodeRecord(lastKey, offset, data)
 }
 
 // Recover inspects S3 and sets w.length to the highest offset present.
 // It is safe to call at startup to initialize the in-memory offset state.
+// In segment mode any buffered records are flushed first.
+//
+// With WithRecoverCacheTTL, concurrent calls share one listing and calls
+// within the TTL of the last listing return its result unless a write
+// through this S3WAL moved the last offset since.
+//
+// With WithManifest, instances share the result of one listing through a
+// manifest object instead of each listing the WAL.
+//
+// For a WAL made with Create, the first Recover fails with ErrFormatMismatch
+// if the options do not produce the key format it was created with.
 func (w *S3WAL) Recover(ctx context.Context) (uint64, error) {
+	if err := w.checkFormat(ctx); err != nil {
+		return 0, err
+	}
+	if w.recoverTTL <= 0 {
+		return w.recoverShared(ctx)
+	}
+	w.mu.Lock()
+	if !w.recoveredAt.IsZero() && time.Since(w.recoveredAt) < w.recoverTTL {
+		length := w.length
+		w.mu.Unlock()
+		return length, nil
+	}
+	w.mu.Unlock()
+
+	v, err, _ := w.recoverGroup.Do("", func() (any, error) {
+		return w.recoverShared(ctx)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return v.(uint64), nil
+}
+
+// RecoverWithStats is Recover that also reports the listing it did, e.g. to
+// tell why recovering a large WAL is slow.
+func (w *S3WAL) RecoverWithStats(ctx context.Context) (uint64, ScanStats, error) {
+	var stats ScanStats
+	if err := w.checkFormat(ctx); err != nil {
+		return 0, stats, err
+	}
+	length, err := w.recover(ctx, &stats)
+	return length, stats, err
+}
+
+func (w *S3WAL) recover(ctx context.Context, stats *ScanStats) (uint64, error) {
+	if err := w.beginOp(); err != nil {
+		return 0, err
+	}
+	defer w.endOp()
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
-	prefix := w.prefix + "/"
+	if err := w.flushLocked(ctx); err != nil {
+		return 0, fmt.Errorf("flush before recover: %w", err)
+	}
+	if w.probeMax > 0 {
+		length, err := w.probeLength(ctx)
+		if err != nil {
+			return 0, fmt.Errorf("probe length during recover: %w", err)
+		}
+		if length == 0 {
+			if length, err = w.trimmedLength(ctx); err != nil {
+				return 0, err
+			}
+		}
+		w.length = length
+		w.recoveredAt = time.Now()
+		return length, nil
+	}
+
+	prefix := w.listPrefix()
 	input := &s3.ListObjectsV2Input{
-		Bucket: aws.String(w.bucketName),
-		Prefix: aws.String(prefix),
+		Bucket:              aws.String(w.bucketName),
+		ExpectedBucketOwner: w.bucketOwner(),
+		RequestPayer:        w.requestPayer,
+		Prefix:              aws.String(prefix),
+		Delimiter:           w.listDelimiter(),
 	}
 
 	paginator := s3.NewListObjectsV2Paginator(w.client, input)
 
 	var maxOffset uint64 = 0
+	trimKey, trimmed := w.trimMarkerKey(), false
 	for paginator.HasMorePages() {
-		page, err := paginator.NextPage(ctx)
+		page, err := paginator.NextPage(ctx, s3Options(ctx)...)
 		if err != nil {
 			return 0, fmt.Errorf("list objects during recover: %w", err)
 		}
+		stats.addPage(page)
 		for _, obj := range page.Contents {
 			if reflect.DeepEqual(obj, types.Object{}) || obj.Key == nil {
 				continue
 			}
-			offset, err := w.getOffsetFromKey(*obj.Key)
+			if *obj.Key == trimKey {
+				trimmed = true
+				continue
+			}
+			_, offset, err := w.getRangeFromKey(*obj.Key)
 			if err != nil {
-				// ignore keys that don't match pattern instead of failing outright
+				// ignore keys that don't match pattern instead of failing outright,
+				// unless WithStrictKeys says otherwise
+				if err := w.unknownKey(*obj.Key); err != nil {
+					return 0, err
+				}
 				continue
 			}
 			if offset > maxOffset {
@@ -262,55 +1326,237 @@ func (w *S3WAL) Recover(ctx context.Context) (uint64, error) {
 		}
 	}
 
+	if trimmed && maxOffset == 0 {
+		// every record was trimmed, continue after the last one
+		trimmedTo, err := w.trimmedLength(ctx)
+		if err != nil {
+			return 0, err
+		}
+		maxOffset = trimmedTo
+	}
+
 	w.length = maxOffset
+	w.recoveredAt = time.Now()
 	return maxOffset, nil
 }
 
 // Truncate deletes all objects with offset > afterOffset. It performs batched DeleteObjects calls.
+// Objects S3 fails to delete do not stop the remaining batches; they are reported
+// together as a *DeleteError and the cached length is left unchanged.
 // If afterOffset == 0, it deletes all objects under the prefix.
+// A segment that straddles afterOffset is rewritten to keep only the records
+// up to afterOffset. A MinValidOffset above afterOffset+1 is moved back to it,
+// as the next record gets that offset.
+//
+// With WithMinRetainedRecords, Truncate returns ErrRetentionViolation instead
+// of deleting anything if it would leave fewer records than the minimum; use
+// ForceTruncate to override.
 func (w *S3WAL) Truncate(ctx context.Context, afterOffset uint64) error {
-	// We do not need to hold w.mu for the duration of the listing and deletion,
-	// but we will update length under lock at the end.
-	prefix := w.prefix + "/"
-	input := &s3.ListObjectsV2Input{
-		Bucket: aws.String(w.bucketName),
-		Prefix: aws.String(prefix),
+	return w.truncateChecked(ctx, afterOffset, nil, nil)
+}
+
+// TruncateWithStats is Truncate that also reports the listing it did,
+// including the one of the WithMinRetainedRecords guard.
+func (w *S3WAL) TruncateWithStats(ctx context.Context, afterOffset uint64) (ScanStats, error) {
+	var stats ScanStats
+	err := w.truncateChecked(ctx, afterOffset, &stats, nil)
+	return stats, err
+}
+
+func (w *S3WAL) truncateChecked(ctx context.Context, afterOffset uint64, stats *ScanStats, result *TruncateResult) (err error) {
+	if len(w.observers) > 0 {
+		defer w.observe(OpTruncate, time.Now(), &err)
+	}
+	if err := w.checkRetention(ctx, afterOffset, stats); err != nil {
+		return err
 	}
+	return w.truncate(ctx, afterOffset, stats, nil, result)
+}
 
-	paginator := s3.NewListObjectsV2Paginator(w.client, input)
+// checkRetention returns ErrRetentionViolation if truncating after
+// afterOffset would leave fewer records than WithMinRetainedRecords.
+func (w *S3WAL) checkRetention(ctx context.Context, afterOffset uint64, stats *ScanStats) error {
+	if w.minRetained <= 0 {
+		return nil
+	}
+	retained, total, err := w.countRecords(ctx, afterOffset, stats)
+	if err != nil {
+		return err
+	}
+	if retained < total && retained < uint64(w.minRetained) {
+		return fmt.Errorf("%w: truncating after offset %d keeps %d of %d records, minimum is %d",
+			ErrRetentionViolation, afterOffset, retained, total, w.minRetained)
+	}
+	return nil
+}
+
+// ForceTruncate is Truncate without the WithMinRetainedRecords guard.
+func (w *S3WAL) ForceTruncate(ctx context.Context, afterOffset uint64) error {
+	return w.truncate(ctx, afterOffset, nil, nil, nil)
+}
+
+// RecoverAndTruncate is Recover followed by Truncate(ctx, afterOffset) with a
+// single listing, for maintenance that does both, and returns the new length:
+// the highest offset left, at most afterOffset. Buffered records are flushed
+// first. With WithMinRetainedRecords the guard still lists the WAL once more.
+func (w *S3WAL) RecoverAndTruncate(ctx context.Context, afterOffset uint64) (_ uint64, err error) {
+	if len(w.observers) > 0 {
+		defer w.observe(OpTruncate, time.Now(), &err)
+	}
+	w.mu.Lock()
+	err = w.flushLocked(ctx)
+	w.mu.Unlock()
+	if err != nil {
+		return 0, fmt.Errorf("flush before recover: %w", err)
+	}
+	if err := w.checkRetention(ctx, afterOffset, nil); err != nil {
+		return 0, err
+	}
+	var length uint64
+	if err := w.truncate(ctx, afterOffset, nil, &length, nil); err != nil {
+		return 0, err
+	}
+	return length, nil
+}
+
+// countRecords returns how many records have an offset <= upTo and how many
+// there are in total, buffered ones included.
+func (w *S3WAL) countRecords(ctx context.Context, upTo uint64, stats *ScanStats) (retained, total uint64, err error) {
+	w.mu.Lock()
+	for _, rec := range w.pending {
+		total++
+		if rec.Offset <= upTo {
+			retained++
+		}
+	}
+	w.mu.Unlock()
+
+	err = w.scanObjects(ctx, stats, func(_ types.Object, first, last uint64) error {
+		total += last - first + 1
+		if first <= upTo {
+			retained += min(last, upTo) - first + 1
+		}
+		return nil
+	})
+	return retained, total, err
+}
+
+// truncate is Truncate without the retention guard. A non-nil recovered
+// makes it list even under WithTrustCachedLength and set the length to the
+// highest offset left, as Recover would, and report it in *recovered.
+func (w *S3WAL) truncate(ctx context.Context, afterOffset uint64, stats *ScanStats, recovered *uint64, result *TruncateResult) error {
+	if err := w.beginOp(); err != nil {
+		return err
+	}
+	defer w.endOp()
+	if w.diskCache != nil {
+		w.diskCache.removeAfter(afterOffset)
+	}
+	w.dropLastWrite(afterOffset+1, math.MaxUint64)
+
+	// buffered records past afterOffset never reached S3, just drop them
+	w.mu.Lock()
+	for len(w.pending) > 0 && w.pending[len(w.pending)-1].Offset > afterOffset {
+		w.pendingBytes -= len(w.pending[len(w.pending)-1].Data)
+		w.pending = w.pending[:len(w.pending)-1]
+	}
+	if w.journal != nil {
+		w.journal.reset(w.pending)
+	}
+	w.mu.Unlock()
 
+	// We do not need to hold w.mu for the duration of the listing and deletion,
+	// but we will update length under lock at the end.
 	var keysToDelete []types.ObjectIdentifier
-	for paginator.HasMorePages() {
-		page, err := paginator.NextPage(ctx)
-		if err != nil {
-			return fmt.Errorf("list objects during truncate: %w", err)
+	// per-object delete failures are collected so the remaining batches still run
+	deleteErr := &DeleteError{}
+	deleteBatch := func() error {
+		err := w.batchDelete(ctx, keysToDelete)
+		var de *DeleteError
+		if err == nil || errors.As(err, &de) {
+			result.addDeleted(w, keysToDelete, de)
 		}
-		for _, obj := range page.Contents {
-			if reflect.DeepEqual(obj, types.Object{}) || obj.Key == nil {
-				continue
+		keysToDelete = keysToDelete[:0]
+		if de != nil {
+			deleteErr.Failed = append(deleteErr.Failed, de.Failed...)
+			return nil
+		}
+		return err
+	}
+	w.mu.Lock()
+	length := w.length
+	w.mu.Unlock()
+	if w.trustLength && w.segment == nil && length > 0 && recovered == nil {
+		// every offset up to length is a plain key, no need to list them
+		for offset := afterOffset + 1; offset <= length; offset++ {
+			keysToDelete = append(keysToDelete, types.ObjectIdentifier{Key: aws.String(w.getObjectKey(offset))})
+			if len(keysToDelete) == 1000 {
+				if err := deleteBatch(); err != nil {
+					return err
+				}
 			}
-			offset, err := w.getOffsetFromKey(*obj.Key)
+		}
+	} else {
+		prefix := w.listPrefix()
+		input := &s3.ListObjectsV2Input{
+			Bucket:              aws.String(w.bucketName),
+			ExpectedBucketOwner: w.bucketOwner(),
+			RequestPayer:        w.requestPayer,
+			Prefix:              aws.String(prefix),
+			Delimiter:           w.listDelimiter(),
+		}
+
+		paginator := s3.NewListObjectsV2Paginator(w.client, input)
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx, s3Options(ctx)...)
 			if err != nil {
-				// ignore non-matching keys
-				continue
-			}
-			if offset > afterOffset {
-				keysToDelete = append(keysToDelete, types.ObjectIdentifier{Key: obj.Key})
+				return fmt.Errorf("list objects during truncate: %w", err)
 			}
-			// batch-delete in chunks of 1000 (S3 limit is 1000)
-			if len(keysToDelete) == 1000 {
-				if err := w.batchDelete(ctx, keysToDelete); err != nil {
-					return err
+			stats.addPage(page)
+			for _, obj := range page.Contents {
+				if reflect.DeepEqual(obj, types.Object{}) || obj.Key == nil {
+					continue
+				}
+				first, last, err := w.getRangeFromKey(*obj.Key)
+				if err != nil {
+					// ignore non-matching keys, unless WithStrictKeys says otherwise
+					if err := w.unknownKey(*obj.Key); err != nil {
+						return err
+					}
+					continue
+				}
+				if recovered != nil && last > *recovered {
+					*recovered = last
+				}
+				if first > afterOffset {
+					keysToDelete = append(keysToDelete, types.ObjectIdentifier{Key: obj.Key})
+				} else if last > afterOffset {
+					if err := w.rewriteSegment(ctx, *obj.Key, first, last, afterOffset); err != nil {
+						return err
+					}
+					result.addOffsets(afterOffset+1, last)
+				}
+				// batch-delete in chunks of 1000 (S3 limit is 1000)
+				if len(keysToDelete) == 1000 {
+					if err := deleteBatch(); err != nil {
+						return err
+					}
 				}
-				keysToDelete = keysToDelete[:0]
 			}
 		}
 	}
 	if len(keysToDelete) > 0 {
-		if err := w.batchDelete(ctx, keysToDelete); err != nil {
+		if err := deleteBatch(); err != nil {
 			return err
 		}
 	}
+	if len(deleteErr.Failed) > 0 {
+		return deleteErr
+	}
+	minValid, err := w.lowerTrimMarker(ctx, afterOffset)
+	if err != nil {
+		return err
+	}
 
 	// update cached length
 	w.mu.Lock()
@@ -319,32 +1565,66 @@ func (w *S3WAL) Truncate(ctx context.Context, afterOffset uint64) error {
 	} else {
 		w.length = afterOffset
 	}
+	w.recoveredAt = time.Time{}
+	if recovered != nil {
+		// offsets up to minValid-1 were handed out even if all were trimmed
+		*recovered = max(min(*recovered, afterOffset), minValid-1)
+		w.length = *recovered
+		w.recoveredAt = time.Now()
+	}
+	w.chainOffset = 0
+	if result != nil {
+		result.NewLength = w.length
+	}
 	w.mu.Unlock()
+	if w.dates != nil {
+		w.dates.dropFrom(afterOffset + 1)
+	}
 	return nil
 }
 
 func (w *S3WAL) batchDelete(ctx context.Context, keys []types.ObjectIdentifier) error {
-	if len(keys) == 0 {
-		return nil
-	}
-	input := &s3.DeleteObjectsInput{
-		Bucket: aws.String(w.bucketName),
-		Delete: &types.Delete{
-			Objects: keys,
-			Quiet:   aws.Bool(false),
-		},
-	}
-	out, err := w.client.DeleteObjects(ctx, input)
-	if err != nil {
-		return fmt.Errorf("delete objects: %w", err)
-	}
-	if len(out.Errors) > 0 {
-		// concatenate errors for better debugging
-		var parts []string
+	de := &DeleteError{}
+	for retry := 0; len(keys) > 0; retry++ {
+		if retry > 0 {
+			if err := sleepCtx(ctx, w.deleteBackoff(retry)); err != nil {
+				return err
+			}
+		}
+		input := &s3.DeleteObjectsInput{
+			Bucket:              aws.String(w.bucketName),
+			ExpectedBucketOwner: w.bucketOwner(),
+			RequestPayer:        w.requestPayer,
+			Delete: &types.Delete{
+				Objects: keys,
+				Quiet:   aws.Bool(false),
+			},
+		}
+		out, err := w.client.DeleteObjects(ctx, input, s3Options(ctx)...)
+		if err != nil {
+			return fmt.Errorf("delete objects: %w", err)
+		}
+
+		// retry only the keys that failed with a retryable code, as long as
+		// retries are left; any other failure is final
+		var again []types.ObjectIdentifier
 		for _, e := range out.Errors {
-			parts = append(parts, fmt.Sprintf("%s: %s", aws.ToString(e.Key), aws.ToString(e.Message)))
+			obj := types.ObjectIdentifier{Key: e.Key, VersionId: e.VersionId}
+			code := aws.ToString(e.Code)
+			if retryableDeleteCodes[code] && retry < w.deleteRetries {
+				again = append(again, obj)
+				continue
+			}
+			de.Failed = append(de.Failed, DeleteFailure{
+				Object:  obj,
+				Code:    code,
+				Message: aws.ToString(e.Message),
+			})
 		}
-		return fmt.Errorf("delete objects errors: %s", strings.Join(parts, "; "))
+		keys = again
+	}
+	if len(de.Failed) > 0 {
+		return de
 	}
 	return nil
 }
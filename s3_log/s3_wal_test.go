@@ -0,0 +1,207 @@
+package s3_log
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// counter is an OffsetAllocator handing out 1, 2, 3...
+type counter uint64
+
+func (c *counter) Next() (uint64, error) {
+	*c++
+	return uint64(*c), nil
+}
+
+// Every constructor rejects what Validate reports: the ones without an error
+// result by panicking.
+func TestConstructorsValidate(t *testing.T) {
+	_, client := newFake(t)
+	// an allocator relies on offsets chosen up front, segments on consecutive ones
+	opts := []Option{WithOffsetAllocator(new(counter)), WithSegmentation(10, 0, 0)}
+
+	mustPanic := func(name string, fn func()) {
+		t.Helper()
+		defer func() {
+			if recover() == nil {
+				t.Errorf("%s accepted an invalid configuration", name)
+			}
+		}()
+		fn()
+	}
+	mustPanic("NewS3WAL", func() { NewS3WAL(client, "bucket", "") })
+	mustPanic("NewS3WALWithAPI", func() { NewS3WALWithAPI(client, "bucket", "") })
+	mustPanic("NewS3WAL", func() { NewS3WAL(client, "bucket", "wal", opts...) })
+
+	if _, err := NewS3WALE(client, "bucket", ""); !errors.Is(err, ErrInvalidPrefix) {
+		t.Errorf("NewS3WALE error = %v, want ErrInvalidPrefix", err)
+	}
+}
+
+func TestGetOffsetFromKey(t *testing.T) {
+	w := newS3WAL(nil, "bucket", "prefix", nil)
+	for _, tc := range []struct {
+		key     string
+		want    uint64
+		wantErr bool
+	}{
+		{key: "prefix/00000000000000000042", want: 42},
+		{key: "prefix/ab/00000000000000000042", want: 42},
+		{key: "prefix/2024/01/02/00000000000000000042", want: 42},
+		{key: "prefix/00000000000000000042/", wantErr: true},
+		{key: "prefix/ab/", wantErr: true},
+		{key: "prefix/ab/42", wantErr: true},
+		{key: "other/00000000000000000042", wantErr: true},
+	} {
+		got, err := w.getOffsetFromKey(tc.key)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("getOffsetFromKey(%q) = %d, want an error", tc.key, got)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("getOffsetFromKey(%q) = %d, %v, want %d", tc.key, got, err, tc.want)
+		}
+	}
+
+	// under WithDelimiterScoping deeper keys belong to nested WALs
+	w = newS3WAL(nil, "bucket", "prefix", []Option{WithDelimiterScoping()})
+	if got, err := w.getOffsetFromKey("prefix/ab/00000000000000000042"); err == nil {
+		t.Errorf("getOffsetFromKey of a nested WAL's key = %d, want an error", got)
+	}
+}
+
+// Keys get the trimmed prefix and a "/", except for an empty prefix under
+// WithRootPrefix, which stores records at the bucket root.
+func TestPrefixKeys(t *testing.T) {
+	for _, tc := range []struct {
+		prefix string
+		opts   []Option
+		key    string
+	}{
+		{"wal", nil, "wal/00000000000000000001"},
+		{"/wal/", nil, "wal/00000000000000000001"},
+		{"a/b", nil, "a/b/00000000000000000001"},
+		{"wal", []Option{WithRootPrefix()}, "wal/00000000000000000001"},
+		{"", []Option{WithRootPrefix()}, "00000000000000000001"},
+		{"/", []Option{WithRootPrefix()}, "00000000000000000001"},
+	} {
+		f, client := newFake(t)
+		w := NewS3WAL(client, "bucket", tc.prefix, tc.opts...)
+		if _, err := w.Append(bg, []byte("data")); err != nil {
+			t.Fatal(err)
+		}
+		w.Close()
+		if keys := f.keys(); len(keys) != 1 || keys[0] != tc.key {
+			t.Errorf("prefix %q: keys = %q, want %q", tc.prefix, keys, tc.key)
+		}
+		if offset, err := w.getOffsetFromKey(tc.key); err != nil || offset != 1 {
+			t.Errorf("prefix %q: getOffsetFromKey(%q) = %d, %v, want 1", tc.prefix, tc.key, offset, err)
+		}
+	}
+}
+
+// A root WAL skips the other objects of its bucket when recovering.
+func TestRootPrefixRecover(t *testing.T) {
+	f, client := newFake(t)
+	w := NewS3WAL(client, "bucket", "", WithRootPrefix())
+	defer w.Close()
+	for i := 0; i < 2; i++ {
+		if _, err := w.Append(bg, []byte("data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f.mu.Lock()
+	f.objects["README"] = fakeObject{data: []byte("hello")}
+	f.mu.Unlock()
+
+	restarted := NewS3WAL(client, "bucket", "", WithRootPrefix())
+	defer restarted.Close()
+	if length, err := restarted.Recover(bg); err != nil || length != 2 {
+		t.Fatalf("Recover = %d, %v, want 2", length, err)
+	}
+}
+
+// Reads fail over to the replica when the primary answers with a server
+// error, but not when it answers that the record does not exist.
+func TestFailoverRead(t *testing.T) {
+	primary, primaryClient := newFake(t)
+	_, replicaClient := newFake(t)
+	for _, client := range []*s3.Client{primaryClient, replicaClient} {
+		w := NewS3WAL(client, "bucket", "wal")
+		if _, err := w.Append(bg, []byte("replicated")); err != nil {
+			t.Fatal(err)
+		}
+		w.Close()
+	}
+	w := NewS3WAL(primaryClient, "bucket", "wal", WithFailoverClient(replicaClient, ""))
+	defer w.Close()
+
+	primary.mu.Lock()
+	primary.down = true
+	primary.mu.Unlock()
+	rec, err := w.Read(bg, 1)
+	if err != nil || string(rec.Data) != "replicated" {
+		t.Fatalf("Read with the primary down = %q, %v, want the replica's record", rec.Data, err)
+	}
+	if rec, err := w.LastRecord(bg); err != nil || rec.Offset != 1 {
+		t.Fatalf("LastRecord with the primary down = %d, %v, want offset 1", rec.Offset, err)
+	}
+
+	// a missing record is final, even if the replica has it
+	primary.mu.Lock()
+	primary.down = false
+	delete(primary.objects, w.getObjectKey(1))
+	primary.mu.Unlock()
+	if _, err := w.Read(bg, 1); err == nil {
+		t.Fatal("Read of a record missing from the primary failed over")
+	}
+}
+
+// WithRequestPayer marks every object request as paid by the requester.
+func TestRequestPayer(t *testing.T) {
+	f, client := newFake(t)
+	w := NewS3WAL(client, "bucket", "wal", WithRequestPayer())
+	defer w.Close()
+	for i := 0; i < 3; i++ {
+		if _, err := w.Append(bg, []byte("data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := w.Read(bg, 2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Recover(bg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Exists(bg, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Truncate(bg, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	reqs := f.requests()
+	if len(reqs) == 0 {
+		t.Fatal("no requests sent")
+	}
+	for _, r := range reqs {
+		if got := r.Header.Get("X-Amz-Request-Payer"); got != "requester" {
+			t.Errorf("%s %s: x-amz-request-payer = %q, want requester", r.Method, r.URL, got)
+		}
+	}
+
+	// and not without the option
+	f, client = newFake(t)
+	w = NewS3WAL(client, "bucket", "wal")
+	defer w.Close()
+	if _, err := w.Append(bg, []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.requests()[0].Header.Get("X-Amz-Request-Payer"); got != "" {
+		t.Errorf("x-amz-request-payer = %q without WithRequestPayer", got)
+	}
+}
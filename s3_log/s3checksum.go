@@ -0,0 +1,65 @@
+package s3_log
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// verifyS3Checksum recomputes the checksums S3 returned for a GetObject of
+// key with ChecksumModeEnabled over data and returns an error wrapping
+// ErrS3ChecksumMismatch if one differs. The SDK validates them too, while
+// streaming; this check runs on the bytes actually handed to the codec.
+// Composite checksums of multipart uploads cover parts, not the object, and
+// CRC64NVME has no standard library implementation, so both are left to the
+// SDK.
+func verifyS3Checksum(key string, data []byte, out *s3.GetObjectOutput) error {
+	if out.ChecksumType == types.ChecksumTypeComposite {
+		return nil
+	}
+	check := func(algo types.ChecksumAlgorithm, stored *string, sum func() []byte) error {
+		if stored == nil || strings.Contains(*stored, "-") {
+			return nil
+		}
+		if got := base64.StdEncoding.EncodeToString(sum()); got != *stored {
+			return fmt.Errorf("object %s: %w: %s stored %s, computed %s",
+				key, ErrS3ChecksumMismatch, algo, *stored, got)
+		}
+		return nil
+	}
+	crc := func(table *crc32.Table) func() []byte {
+		return func() []byte {
+			return binary.BigEndian.AppendUint32(nil, crc32.Checksum(data, table))
+		}
+	}
+	if err := check(types.ChecksumAlgorithmCrc32c, out.ChecksumCRC32C, crc(crc32.MakeTable(crc32.Castagnoli))); err != nil {
+		return err
+	}
+	if err := check(types.ChecksumAlgorithmCrc32, out.ChecksumCRC32, crc(crc32.IEEETable)); err != nil {
+		return err
+	}
+	if err := check(types.ChecksumAlgorithmSha256, out.ChecksumSHA256, func() []byte {
+		sum := sha256.Sum256(data)
+		return sum[:]
+	}); err != nil {
+		return err
+	}
+	return check(types.ChecksumAlgorithmSha1, out.ChecksumSHA1, func() []byte {
+		sum := sha1.Sum(data)
+		return sum[:]
+	})
+}
+
+// isSDKChecksumMismatch reports whether err is the SDK failing its own
+// validation of a response checksum while the body was read. Its error type
+// is internal to the SDK, so only its message identifies it.
+func isSDKChecksumMismatch(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "checksum did not match")
+}
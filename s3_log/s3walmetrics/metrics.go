@@ -0,0 +1,80 @@
+// Package s3walmetrics exports the operation metrics of an s3_log WAL to
+// Prometheus. It lives apart from s3_log so that the core does not depend on
+// the Prometheus client.
+//
+//	w := s3_log.NewS3WAL(client, bucket, prefix, s3walmetrics.WithPrometheus(prometheus.DefaultRegisterer))
+package s3walmetrics
+
+import (
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"s3-wal-demo/s3_log"
+)
+
+// Collector is a prometheus.Collector of per-operation latency histograms
+// and error counters, labelled by operation ("append", "read", "truncate").
+// Observe is its s3_log.Observer.
+type Collector struct {
+	latency *prometheus.HistogramVec
+	errors  *prometheus.CounterVec
+}
+
+// NewCollector returns a Collector whose metrics are named
+// s3wal_operation_duration_seconds and s3wal_operation_errors_total.
+func NewCollector() *Collector {
+	return &Collector{
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "s3wal",
+			Name:      "operation_duration_seconds",
+			Help:      "Latency of WAL operations, including failed ones.",
+			Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+		}, []string{"operation"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "s3wal",
+			Name:      "operation_errors_total",
+			Help:      "WAL operations that returned an error.",
+		}, []string{"operation"}),
+	}
+}
+
+// Observe records one operation. Pass it to s3_log.WithObserver.
+func (c *Collector) Observe(op s3_log.Op, elapsed time.Duration, err error) {
+	c.latency.WithLabelValues(string(op)).Observe(elapsed.Seconds())
+	if err != nil {
+		c.errors.WithLabelValues(string(op)).Inc()
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	c.latency.Describe(ch)
+	c.errors.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.latency.Collect(ch)
+	c.errors.Collect(ch)
+}
+
+// WithPrometheus registers a Collector with reg and observes the WAL's
+// operations with it. WALs sharing reg share the Collector, so their
+// operations add up. It panics if reg holds other metrics of the same names.
+func WithPrometheus(reg prometheus.Registerer) s3_log.Option {
+	c := NewCollector()
+	if err := reg.Register(c); err != nil {
+		var are prometheus.AlreadyRegisteredError
+		if !errors.As(err, &are) {
+			panic("s3walmetrics: register collector: " + err.Error())
+		}
+		existing, ok := are.ExistingCollector.(*Collector)
+		if !ok {
+			panic("s3walmetrics: register collector: " + err.Error())
+		}
+		c = existing
+	}
+	return s3_log.WithObserver(c.Observe)
+}
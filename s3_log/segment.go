@@ -0,0 +1,400 @@
+package s3_log
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// segmentMagic marks an object that packs several records. A plain record
+// starts with its 8-byte big-endian offset, which can never collide with
+// these bytes for any realistic offset.
+var segmentMagic = []byte("S3WALSEG")
+
+// segmentConfig holds the thresholds set by WithSegmentation.
+type segmentConfig struct {
+	maxRecords    int
+	maxBytes      int
+	flushInterval time.Duration
+}
+
+// getSegmentKey builds the object key for a segment holding first..last.
+// Segments are keyed by their last offset first so that key order still
+// follows offset order and a segment sorts right after the plain key of its
+// last record: <prefix><separator><last>-<first>. The first offset is always
+// 20 digits, also under WithKeyTransform.
+func (w *S3WAL) getSegmentKey(first, last uint64) string {
+	return w.getObjectKey(last) + "-" + fmt.Sprintf("%020d", first)
+}
+
+// getRangeFromKey extracts the offsets covered by an object key. Plain record
+// keys cover a single offset; segment keys cover first..last.
+func (w *S3WAL) getRangeFromKey(key string) (first, last uint64, err error) {
+	name, err := w.keyName(key)
+	if err != nil {
+		return 0, 0, err
+	}
+	// a segment key ends in "-" and the 20-digit first offset; a name that
+	// merely looks like one under WithKeyTransform is taken as a plain key
+	if i := len(name) - 21; i >= 0 && name[i] == '-' {
+		if first, err := parseOffset(name[i+1:]); err == nil {
+			if last, err := w.parseOffsetName(name[:i]); err == nil {
+				if first > last {
+					return 0, 0, fmt.Errorf("invalid segment key %q: first offset after last", key)
+				}
+				return first, last, nil
+			}
+		}
+	}
+	last, err = w.parseOffsetName(name)
+	if err != nil {
+		return 0, 0, err
+	}
+	return last, last, nil
+}
+
+// encodeSegment writes: [magic][4-byte count][count x 4-byte body length][bodies]
+// where every body is a regular record body as produced by the codec. It also
+// returns the last body. Under WithHashChain every record but the first is
+// chained to the one before it; the first keeps the PrevHash it was given.
+func (w *S3WAL) encodeSegment(records []Record) (segment, lastBody []byte, err error) {
+	bodies := make([][]byte, len(records))
+	for i, rec := range records {
+		if w.hashChain && i > 0 {
+			rec.PrevHash = chainHash(bodies[i-1])
+		}
+		body, err := w.codec.Marshal(rec)
+		if err != nil {
+			return nil, nil, fmt.Errorf("prepare body (offset=%d): %w", rec.Offset, err)
+		}
+		bodies[i] = body
+	}
+	return packSegment(bodies), bodies[len(bodies)-1], nil
+}
+
+// packSegment lays out encoded record bodies as a segment.
+func packSegment(bodies [][]byte) []byte {
+	size := len(segmentMagic) + 4 + 4*len(bodies)
+	for _, body := range bodies {
+		size += len(body)
+	}
+	buf := make([]byte, 0, size)
+	buf = append(buf, segmentMagic...)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(bodies)))
+	for _, body := range bodies {
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(body)))
+	}
+	for _, body := range bodies {
+		buf = append(buf, body...)
+	}
+	return buf
+}
+
+// isSegment reports whether an object body is a segment.
+func isSegment(data []byte) bool {
+	return bytes.HasPrefix(data, segmentMagic)
+}
+
+// segmentBodies splits a segment into the bodies of the records it holds.
+func segmentBodies(data []byte) ([][]byte, error) {
+	if !isSegment(data) {
+		return nil, errors.New("not a segment")
+	}
+	pos := len(segmentMagic)
+	if len(data) < pos+4 {
+		return nil, errors.New("segment too short for count")
+	}
+	// lengths are compared before converting to int, which may be 32 bits
+	count32 := binary.BigEndian.Uint32(data[pos:])
+	pos += 4
+	if uint64(count32) > uint64((len(data)-pos)/4) {
+		return nil, fmt.Errorf("segment index truncated (count=%d)", count32)
+	}
+	count := int(count32)
+
+	bodies := make([][]byte, count)
+	start := pos + 4*count
+	for i := 0; i < count; i++ {
+		n := binary.BigEndian.Uint32(data[pos+4*i:])
+		if uint64(n) > uint64(len(data)-start) {
+			return nil, fmt.Errorf("segment body %d truncated", i)
+		}
+		bodies[i] = data[start : start+int(n)]
+		start += int(n)
+	}
+	return bodies, nil
+}
+
+// decodeSegmentRecord returns the record at offset from a segment covering first..last.
+func (w *S3WAL) decodeSegmentRecord(key string, data []byte, first, last, offset uint64) (Record, error) {
+	bodies, err := segmentBodies(data)
+	if err != nil {
+		return Record{}, fmt.Errorf("parse segment %s: %w", key, err)
+	}
+	if uint64(len(bodies)) != last-first+1 {
+		return Record{}, fmt.Errorf("segment %s holds %d records, key says %d", key, len(bodies), last-first+1)
+	}
+	if offset < first || offset > last {
+		return Record{}, fmt.Errorf("offset %d is outside segment %s", offset, key)
+	}
+	return w.decodeRecord(key, offset, bodies[offset-first])
+}
+
+// decodeSegment returns every record held by a segment covering first..last.
+func (w *S3WAL) decodeSegment(key string, data []byte, first, last uint64) ([]Record, error) {
+	bodies, err := segmentBodies(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse segment %s: %w", key, err)
+	}
+	if uint64(len(bodies)) != last-first+1 {
+		return nil, fmt.Errorf("segment %s holds %d records, key says %d", key, len(bodies), last-first+1)
+	}
+	records := make([]Record, len(bodies))
+	for i, body := range bodies {
+		rec, err := w.decodeRecord(key, first+uint64(i), body)
+		if err != nil {
+			return nil, err
+		}
+		records[i] = rec
+	}
+	return records, nil
+}
+
+// putSegment uploads records (which must have consecutive offsets) as one
+// segment object and returns the body of the last record.
+func (w *S3WAL) putSegment(ctx context.Context, records []Record) ([]byte, error) {
+	first, last := records[0].Offset, records[len(records)-1].Offset
+	body, lastBody, err := w.encodeSegment(records)
+	if err != nil {
+		return nil, err
+	}
+	if err := w.putSegmentBody(ctx, first, last, body, time.Time{}); err != nil {
+		return nil, err
+	}
+	return lastBody, nil
+}
+
+// putSegmentBody uploads a packed segment covering first..last. A non-zero
+// written is saved as the segment's coalescedMetaKey.
+func (w *S3WAL) putSegmentBody(ctx context.Context, first, last uint64, body []byte, written time.Time) error {
+	input := &s3.PutObjectInput{
+		Bucket:              aws.String(w.bucketName),
+		ACL:                 w.acl,
+		ExpectedBucketOwner: w.bucketOwner(),
+		RequestPayer:        w.requestPayer,
+		Key:                 aws.String(w.getSegmentKey(first, last)),
+		Body:                bytes.NewReader(body),
+		ChecksumAlgorithm:   w.checksumAlgorithm,
+	}
+	if !written.IsZero() {
+		input.Metadata = map[string]string{coalescedMetaKey: written.UTC().Format(time.RFC3339Nano)}
+	}
+	if _, err := w.client.PutObject(ctx, input, s3Options(ctx)...); err != nil {
+		return fmt.Errorf("put segment (offsets=%d-%d): %w", first, last, err)
+	}
+	return w.verifyVisible(ctx, *input.Key)
+}
+
+// locateSegment finds the segment object holding offset. Because segments are
+// keyed by their last offset, the first key after the plain key of offset is
+// the only candidate. With WithHashPrefix the whole WAL is listed instead.
+func (w *S3WAL) locateSegment(ctx context.Context, offset uint64) (key string, first, last uint64, found bool, err error) {
+	if w.hashChars > 0 {
+		err = w.eachObject(ctx, func(obj types.Object, f, l uint64) error {
+			if f <= offset && offset <= l {
+				key, first, last, found = aws.ToString(obj.Key), f, l, true
+			}
+			return nil
+		})
+		return key, first, last, found, err
+	}
+	input := &s3.ListObjectsV2Input{
+		Bucket:              aws.String(w.bucketName),
+		ExpectedBucketOwner: w.bucketOwner(),
+		RequestPayer:        w.requestPayer,
+		Prefix:              aws.String(w.listPrefix()),
+		Delimiter:           w.listDelimiter(),
+		StartAfter:          aws.String(w.getObjectKey(offset)),
+		MaxKeys:             aws.Int32(1),
+	}
+	out, err := w.client.ListObjectsV2(ctx, input, s3Options(ctx)...)
+	if err != nil {
+		return "", 0, 0, false, fmt.Errorf("list objects to locate offset %d: %w", offset, err)
+	}
+	if len(out.Contents) == 0 || out.Contents[0].Key == nil {
+		return "", 0, 0, false, nil
+	}
+	key = *out.Contents[0].Key
+	first, last, err = w.getRangeFromKey(key)
+	if err != nil || offset < first || offset > last {
+		return "", 0, 0, false, nil
+	}
+	return key, first, last, true, nil
+}
+
+// pendingRecord returns a buffered, not yet flushed record. Callers must hold w.mu.
+func (w *S3WAL) pendingRecord(offset uint64) (Record, bool) {
+	if len(w.pending) == 0 {
+		return Record{}, false
+	}
+	first := w.pending[0].Offset
+	if offset < first || offset-first >= uint64(len(w.pending)) {
+		return Record{}, false
+	}
+	rec := w.pending[offset-first]
+	data := make([]byte, len(rec.Data))
+	copy(data, rec.Data)
+	return Record{Offset: rec.Offset, Data: data}, true
+}
+
+// appendToSegment buffers a record for the next segment and flushes once a
+// threshold is reached. Callers must hold w.mu. If the flush fails the record
+// is dropped again so that a failed Append leaves no trace.
+func (w *S3WAL) appendToSegment(ctx context.Context, offset uint64, data []byte) error {
+	// retry a failed background flush before accepting more records
+	if w.flushErr != nil {
+		if err := w.flushLocked(ctx); err != nil {
+			return fmt.Errorf("flush pending segment: %w", err)
+		}
+		w.flushErr = nil
+	}
+
+	if w.journal != nil {
+		if err := w.journal.append(offset, data); err != nil {
+			return err
+		}
+	}
+	buf := make([]byte, len(data))
+	copy(buf, data)
+	w.pending = append(w.pending, Record{Offset: offset, Data: buf})
+	w.pendingBytes += len(data)
+
+	if w.shouldFlush() {
+		if err := w.flushLocked(ctx); err != nil {
+			w.pending = w.pending[:len(w.pending)-1]
+			w.pendingBytes -= len(data)
+			if w.journal != nil {
+				w.journal.undo()
+			}
+			return err
+		}
+	}
+	w.length = offset
+	w.recoveredAt = time.Time{}
+	return nil
+}
+
+// shouldFlush reports whether the buffered records reached a segment threshold.
+// Callers must hold w.mu.
+func (w *S3WAL) shouldFlush() bool {
+	if w.segment.maxRecords > 0 && len(w.pending) >= w.segment.maxRecords {
+		return true
+	}
+	return w.segment.maxBytes > 0 && w.pendingBytes >= w.segment.maxBytes
+}
+
+// flushLocked uploads the buffered records as one segment. Callers must hold w.mu.
+// On failure the records stay buffered so the next flush retries them.
+func (w *S3WAL) flushLocked(ctx context.Context) error {
+	if len(w.pending) == 0 {
+		return nil
+	}
+	if w.hashChain {
+		prev, err := w.chainPrev(ctx, w.pending[0].Offset)
+		if err != nil {
+			return err
+		}
+		w.pending[0].PrevHash = prev
+	}
+	if w.dates != nil {
+		w.dates.startAt(w.pending[0].Offset, time.Now())
+	}
+	lastBody, err := w.putSegment(ctx, w.pending)
+	if err != nil {
+		return err
+	}
+	if w.hashChain {
+		w.setChainTail(w.pending[len(w.pending)-1].Offset, chainHash(lastBody))
+	}
+	w.pending = nil
+	w.pendingBytes = 0
+	if w.journal != nil {
+		w.journal.reset(nil)
+	}
+	return nil
+}
+
+// Flush uploads any records buffered by WithSegmentation. It is a no-op in
+// the default one-object-per-record mode.
+func (w *S3WAL) Flush(ctx context.Context) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.flushErr = nil
+	return w.flushLocked(ctx)
+}
+
+// Close stops the background segment flusher and StartMaintenance, and
+// flushes buffered records. It is safe to call more than once.
+func (w *S3WAL) Close() error {
+	w.stopMaintenance()
+	w.closeOnce.Do(func() {
+		if w.flushStop != nil {
+			close(w.flushStop)
+			<-w.flushDone
+		}
+	})
+	return w.Flush(context.Background())
+}
+
+// runFlusher flushes buffered records every flushInterval until Close is called.
+// A failed background flush is reported by the next Append.
+func (w *S3WAL) runFlusher() {
+	defer close(w.flushDone)
+	ticker := time.NewTicker(w.segment.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.flushStop:
+			return
+		case <-ticker.C:
+			w.mu.Lock()
+			if err := w.flushLocked(context.Background()); err != nil {
+				w.flushErr = err
+			}
+			w.mu.Unlock()
+		}
+	}
+}
+
+// rewriteSegment replaces a segment covering first..last with one that only
+// keeps the records up to and including afterOffset.
+func (w *S3WAL) rewriteSegment(ctx context.Context, key string, first, last, afterOffset uint64) error {
+	data, err := w.getBody(ctx, key)
+	if err != nil {
+		return err
+	}
+	records, err := w.decodeSegment(key, data, first, last)
+	if err != nil {
+		return err
+	}
+	if _, err := w.putSegment(ctx, records[:afterOffset-first+1]); err != nil {
+		return err
+	}
+	_, err = w.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket:              aws.String(w.bucketName),
+		ExpectedBucketOwner: w.bucketOwner(),
+		RequestPayer:        w.requestPayer,
+		Key:                 aws.String(key),
+	}, s3Options(ctx)...)
+	if err != nil {
+		return fmt.Errorf("delete segment %s: %w", key, err)
+	}
+	return nil
+}
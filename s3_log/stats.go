@@ -0,0 +1,86 @@
+package s3_log
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// WALStats summarizes the objects stored for a WAL.
+type WALStats struct {
+	Objects     int    // record and segment objects
+	Records     int    // records they hold
+	Bytes       int64  // total object size
+	FirstOffset uint64 // lowest stored offset, 0 if empty
+	LastOffset  uint64 // highest stored offset, 0 if empty
+
+	// SizeHistogram is the distribution of object sizes, nil unless
+	// requested with WithSizeHistogram.
+	SizeHistogram *SizeHistogram
+}
+
+// SizeHistogram counts objects by size. Counts[i] is the number of objects
+// larger than Bounds[i-1] and at most Bounds[i]; the extra last entry counts
+// the objects larger than every bound.
+type SizeHistogram struct {
+	Bounds []int64
+	Counts []int
+}
+
+// StatsOption configures Stats.
+type StatsOption func(*statsConfig)
+
+type statsConfig struct {
+	histogramBounds []int64
+}
+
+// WithSizeHistogram makes Stats also compute a SizeHistogram of object sizes
+// with the given bucket upper bounds, e.g. to see whether a WAL holds many
+// tiny records that segmentation would pack. bounds must be ascending.
+func WithSizeHistogram(bounds ...int64) StatsOption {
+	if len(bounds) == 0 || !sort.SliceIsSorted(bounds, func(i, j int) bool { return bounds[i] < bounds[j] }) {
+		panic(fmt.Sprintf("s3_log: invalid histogram bounds %v", bounds))
+	}
+	return func(c *statsConfig) {
+		c.histogramBounds = append([]int64(nil), bounds...)
+	}
+}
+
+// Stats lists the WAL and summarizes what is stored. A segment is one object
+// holding many records. Buffered records are not included.
+func (w *S3WAL) Stats(ctx context.Context, opts ...StatsOption) (WALStats, error) {
+	var cfg statsConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	var st WALStats
+	if cfg.histogramBounds != nil {
+		st.SizeHistogram = &SizeHistogram{
+			Bounds: cfg.histogramBounds,
+			Counts: make([]int, len(cfg.histogramBounds)+1),
+		}
+	}
+	err := w.eachObject(ctx, func(obj types.Object, first, last uint64) error {
+		size := aws.ToInt64(obj.Size)
+		st.Objects++
+		st.Records += int(last - first + 1)
+		st.Bytes += size
+		if st.FirstOffset == 0 || first < st.FirstOffset {
+			st.FirstOffset = first
+		}
+		st.LastOffset = max(st.LastOffset, last)
+		if h := st.SizeHistogram; h != nil {
+			i := sort.Search(len(h.Bounds), func(i int) bool { return h.Bounds[i] >= size })
+			h.Counts[i]++
+		}
+		return nil
+	})
+	if err != nil {
+		return WALStats{}, err
+	}
+	return st, nil
+}
@@ -0,0 +1,116 @@
+package s3_log
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// StreamReaderAt presents the payloads of all stored records, concatenated in
+// offset order, as one blob of the returned size. Building its index reads
+// every record once, since payload sizes cannot be told from object sizes;
+// ReadAt then downloads the objects a read touches, keeping the last one in
+// memory. The blob reflects the records stored when it was built: later
+// appends are not visible and truncated records fail to read.
+//
+// The ReaderAt is safe for concurrent use and performs its requests with ctx.
+func (w *S3WAL) StreamReaderAt(ctx context.Context) (io.ReaderAt, int64, error) {
+	r := &streamReader{ctx: ctx, w: w, cachedObj: -1}
+	var pos int64
+	err := w.walkObjects(ctx, func(obj types.Object, first, last uint64) error {
+		key := aws.ToString(obj.Key)
+		records, err := w.readObject(ctx, key, first, last)
+		if err != nil {
+			return err
+		}
+		objIdx := len(r.objects)
+		r.objects = append(r.objects, streamObject{key, first, last})
+		for i, rec := range records {
+			r.chunks = append(r.chunks, streamChunk{start: pos, obj: objIdx, index: i})
+			pos += int64(len(rec.Data))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	r.size = pos
+	return r, pos, nil
+}
+
+type streamObject struct {
+	key         string
+	first, last uint64
+}
+
+// streamChunk is one record's payload within the stream.
+type streamChunk struct {
+	start int64 // position of the first payload byte in the stream
+	obj   int   // index into streamReader.objects
+	index int   // position of the record within its object
+}
+
+type streamReader struct {
+	ctx     context.Context
+	w       *S3WAL
+	objects []streamObject
+	chunks  []streamChunk // in stream order
+	size    int64
+
+	mu            sync.Mutex
+	cachedObj     int
+	cachedRecords []Record
+}
+
+func (r *streamReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("s3_log: negative stream offset")
+	}
+	if off >= r.size {
+		return 0, io.EOF
+	}
+
+	// the last chunk starting at or before off
+	i := sort.Search(len(r.chunks), func(i int) bool { return r.chunks[i].start > off }) - 1
+	n := 0
+	for n < len(p) && i < len(r.chunks) {
+		data, err := r.chunkData(i)
+		if err != nil {
+			return n, err
+		}
+		rel := off + int64(n) - r.chunks[i].start
+		if rel > int64(len(data)) {
+			return n, errors.New("s3_log: record changed since the stream index was built")
+		}
+		n += copy(p[n:], data[rel:])
+		i++
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// chunkData returns the payload of chunk i, downloading its object if needed.
+func (r *streamReader) chunkData(i int) ([]byte, error) {
+	c := r.chunks[i]
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cachedObj != c.obj {
+		obj := r.objects[c.obj]
+		records, err := r.w.readObject(r.ctx, obj.key, obj.first, obj.last)
+		if err != nil {
+			return nil, err
+		}
+		r.cachedObj, r.cachedRecords = c.obj, records
+	}
+	if c.index >= len(r.cachedRecords) {
+		return nil, errors.New("s3_log: record changed since the stream index was built")
+	}
+	return r.cachedRecords[c.index].Data, nil
+}
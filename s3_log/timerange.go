@@ -0,0 +1,58 @@
+package s3_log
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// StreamTimeRange emits the stored records written between start and end,
+// inclusive, in offset order. Like TruncateBeforeTime it takes a record's
+// time from the LastModified time of its object, so all records of a segment
+// share one, at S3's resolution of one second. Objects older than start are
+// skipped without being downloaded, and the stream ends at the first object
+// newer than end; a record rewritten later, by Overwrite or a segment
+// rewrite, counts as new.
+//
+// Both channels are closed when the range is exhausted, when ctx is cancelled
+// or after the first error, which is sent on the error channel. Buffered
+// records are not included.
+func (w *S3WAL) StreamTimeRange(ctx context.Context, start, end time.Time) (<-chan Record, <-chan error) {
+	records := make(chan Record)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(errs)
+		defer close(records)
+
+		err := w.walkObjects(ctx, func(obj types.Object, first, last uint64) error {
+			modified := aws.ToTime(obj.LastModified)
+			if modified.Before(start) {
+				return nil
+			}
+			if modified.After(end) {
+				return errStopWalk
+			}
+			objRecords, err := w.readObject(ctx, aws.ToString(obj.Key), first, last)
+			if err != nil {
+				return err
+			}
+			for _, rec := range objRecords {
+				select {
+				case records <- rec:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, errStopWalk) && ctx.Err() == nil {
+			errs <- err
+		}
+	}()
+
+	return records, errs
+}
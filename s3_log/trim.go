@@ -0,0 +1,106 @@
+package s3_log
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// MinValidOffset returns the lowest offset that TruncateBeforeTime has not
+// deleted, as recorded in the trim marker object, or 1 if the front of the
+// WAL was never trimmed. A consumer whose next offset is below it has fallen
+// behind the retention and should continue from it, rather than treat the
+// missing record as a transient error.
+func (w *S3WAL) MinValidOffset(ctx context.Context) (uint64, error) {
+	key := w.trimMarkerKey()
+	out, err := w.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket:              aws.String(w.bucketName),
+		ExpectedBucketOwner: w.bucketOwner(),
+		RequestPayer:        w.requestPayer,
+		Key:                 aws.String(key),
+	}, s3Options(ctx)...)
+	if err != nil {
+		if isNotFound(err) {
+			return 1, nil
+		}
+		return 0, fmt.Errorf("get trim marker %s: %w", key, err)
+	}
+	defer out.Body.Close()
+
+	data, err := io.ReadAll(out.Body)
+	if err != nil {
+		return 0, fmt.Errorf("read trim marker %s: %w", key, err)
+	}
+	offset, err := strconv.ParseUint(string(data), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parse trim marker %s: %w", key, err)
+	}
+	return offset, nil
+}
+
+// saveTrimMarker records offset as the lowest valid one. TruncateBeforeTime
+// saves it before deleting, so that a consumer never finds a record missing
+// while the marker still covers it.
+func (w *S3WAL) saveTrimMarker(ctx context.Context, offset uint64) error {
+	key := w.trimMarkerKey()
+	_, err := w.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:              aws.String(w.bucketName),
+		ACL:                 w.acl,
+		ExpectedBucketOwner: w.bucketOwner(),
+		RequestPayer:        w.requestPayer,
+		Key:                 aws.String(key),
+		Body:                bytes.NewReader([]byte(strconv.FormatUint(offset, 10))),
+	}, s3Options(ctx)...)
+	if err != nil {
+		return fmt.Errorf("put trim marker %s: %w", key, err)
+	}
+	return nil
+}
+
+// trimmedLength returns the highest offset TruncateBeforeTime deleted, as
+// recorded by the trim marker, or 0. Once every record was trimmed it is the
+// only trace of the offsets already handed out, so the length must not fall
+// below it.
+func (w *S3WAL) trimmedLength(ctx context.Context) (uint64, error) {
+	minValid, err := w.MinValidOffset(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return minValid - 1, nil
+}
+
+// lowerTrimMarker moves the trim marker back after a Truncate that deleted
+// everything from afterOffset+1 on: if the marker is above that, the WAL is
+// empty and the next record gets offset afterOffset+1. It returns the
+// marker's offset afterwards, 1 if there is none.
+func (w *S3WAL) lowerTrimMarker(ctx context.Context, afterOffset uint64) (uint64, error) {
+	minValid, err := w.MinValidOffset(ctx)
+	if err != nil || minValid <= afterOffset+1 {
+		return minValid, err
+	}
+	if afterOffset > 0 {
+		return afterOffset + 1, w.saveTrimMarker(ctx, afterOffset+1)
+	}
+	key := w.trimMarkerKey()
+	_, err = w.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket:              aws.String(w.bucketName),
+		ExpectedBucketOwner: w.bucketOwner(),
+		RequestPayer:        w.requestPayer,
+		Key:                 aws.String(key),
+	}, s3Options(ctx)...)
+	if err != nil {
+		return 0, fmt.Errorf("delete trim marker %s: %w", key, err)
+	}
+	return 1, nil
+}
+
+// trimMarkerKey is the object holding the lowest valid offset. Like
+// checkpoints it never parses as an offset, so scans skip it.
+func (w *S3WAL) trimMarkerKey() string {
+	return w.listPrefix() + "_trim_marker"
+}
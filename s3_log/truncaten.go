@@ -0,0 +1,85 @@
+package s3_log
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// TruncateN is Truncate in steps of at most maxDelete deleted objects, so
+// that a large truncation can be spread over calls, paced and checkpointed:
+//
+//	for {
+//		_, done, err := w.TruncateN(ctx, 0, 10000)
+//		if err != nil { ... }
+//		if done {
+//			break
+//		}
+//	}
+//
+// Each call lists the WAL and, while more than maxDelete objects are past
+// afterOffset, deletes the maxDelete with the highest offsets, so the stored
+// records stay a contiguous range between calls. The call that finds at most
+// maxDelete left does a full Truncate, which also rewrites a segment
+// straddling afterOffset, drops buffered records and updates the length, and
+// reports done. Until then the cached length is left alone: do not append
+// while a truncation is in progress.
+func (w *S3WAL) TruncateN(ctx context.Context, afterOffset uint64, maxDelete int) (deleted int, done bool, err error) {
+	if maxDelete <= 0 {
+		return 0, false, fmt.Errorf("s3_log: invalid max delete %d", maxDelete)
+	}
+	if len(w.observers) > 0 {
+		defer w.observe(OpTruncate, time.Now(), &err)
+	}
+	if err := w.checkRetention(ctx, afterOffset, nil); err != nil {
+		return 0, false, err
+	}
+	if err := w.beginOp(); err != nil {
+		return 0, false, err
+	}
+	defer w.endOp()
+
+	// the last maxDelete objects past afterOffset, in offset order
+	var tail []types.Object
+	total := 0
+	err = w.walkObjects(ctx, func(obj types.Object, first, last uint64) error {
+		if first <= afterOffset {
+			return nil
+		}
+		total++
+		tail = append(tail, obj)
+		if len(tail) == 2*maxDelete {
+			tail = append(tail[:0], tail[maxDelete:]...)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	if total <= maxDelete {
+		if err := w.truncate(ctx, afterOffset, nil, nil, nil); err != nil {
+			return 0, false, err
+		}
+		return total, true, nil
+	}
+
+	tail = tail[len(tail)-maxDelete:]
+	keys := make([]types.ObjectIdentifier, len(tail))
+	for i, obj := range tail {
+		keys[i] = types.ObjectIdentifier{Key: obj.Key}
+		if first, last, err := w.getRangeFromKey(*obj.Key); err == nil {
+			w.dropLastWrite(first, last)
+			if w.diskCache != nil {
+				for offset := first; offset <= last; offset++ {
+					w.diskCache.remove(offset)
+				}
+			}
+		}
+	}
+	if err := w.deleteKeys(ctx, keys); err != nil {
+		return 0, false, err
+	}
+	return len(keys), false, nil
+}
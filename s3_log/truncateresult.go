@@ -0,0 +1,68 @@
+package s3_log
+
+import (
+	"context"
+	"slices"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// TruncateResult records what a truncation removed, see TruncateWithResult.
+type TruncateResult struct {
+	// DeletedCount is the number of objects deleted. A segment holds several
+	// records, so it may be lower than len(DeletedOffsets).
+	DeletedCount int
+	// DeletedOffsets are the offsets of the records removed from S3, in
+	// ascending order, including those cut from a segment that was rewritten.
+	DeletedOffsets []uint64
+	// NewLength is the length after the truncation; 0 if it failed.
+	NewLength uint64
+}
+
+// TruncateWithResult is Truncate that also returns which records it removed,
+// for an audit trail. On error the result still lists the objects deleted
+// before the failure. Under WithTrustCachedLength the offsets are those of
+// every key up to the cached length that DeleteObjects accepted, as S3 does
+// not tell whether a key existed.
+func (w *S3WAL) TruncateWithResult(ctx context.Context, afterOffset uint64) (TruncateResult, error) {
+	var result TruncateResult
+	err := w.truncateChecked(ctx, afterOffset, nil, &result)
+	slices.Sort(result.DeletedOffsets)
+	return result, err
+}
+
+// addDeleted adds the keys of a DeleteObjects batch to r, except the ones in
+// failed. A nil r records nothing.
+func (r *TruncateResult) addDeleted(w *S3WAL, keys []types.ObjectIdentifier, failed *DeleteError) {
+	if r == nil {
+		return
+	}
+	var failedKeys map[string]bool
+	if failed != nil {
+		failedKeys = make(map[string]bool, len(failed.Failed))
+		for _, f := range failed.Failed {
+			failedKeys[aws.ToString(f.Object.Key)] = true
+		}
+	}
+	for _, k := range keys {
+		key := aws.ToString(k.Key)
+		if failedKeys[key] {
+			continue
+		}
+		r.DeletedCount++
+		if first, last, err := w.getRangeFromKey(key); err == nil {
+			r.addOffsets(first, last)
+		}
+	}
+}
+
+// addOffsets adds first..last to r.DeletedOffsets. A nil r records nothing.
+func (r *TruncateResult) addOffsets(first, last uint64) {
+	if r == nil {
+		return
+	}
+	for offset := first; offset <= last; offset++ {
+		r.DeletedOffsets = append(r.DeletedOffsets, offset)
+	}
+}